@@ -6,12 +6,25 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
 type Cache struct {
 	WebSocketURL string `json:"websocket_url"`
-	mu           sync.RWMutex
-	cacheFile    string
+	// WebSocketURLSaved is when WebSocketURL was received.
+	WebSocketURLSaved time.Time `json:"websocket_url_saved"`
+	mu                sync.RWMutex
+	cacheFile         string
+	overrideFile      string
+	usageFile         string
+}
+
+// Override is a manual schedule override that lasts from From until Until.
+// Mode is "active" or "away".
+type Override struct {
+	Mode  string    `json:"mode"`
+	From  time.Time `json:"from"`
+	Until time.Time `json:"until"`
 }
 
 func NewCache(cacheDir string) (*Cache, error) {
@@ -22,7 +35,9 @@ func NewCache(cacheDir string) (*Cache, error) {
 
 	cacheFile := filepath.Join(cacheDir, "websocket_cache.json")
 	cache := &Cache{
-		cacheFile: cacheFile,
+		cacheFile:    cacheFile,
+		overrideFile: filepath.Join(cacheDir, "override.json"),
+		usageFile:    filepath.Join(cacheDir, "usage.json"),
 	}
 
 	// Load existing cache if it exists
@@ -60,15 +75,123 @@ func (c *Cache) save() error {
 	return os.WriteFile(c.cacheFile, data, 0644)
 }
 
-func (c *Cache) GetWebSocketURL() string {
+// GetWebSocketURL returns the cached WebSocket URL and when it was saved,
+// or an empty URL if none is cached.
+func (c *Cache) GetWebSocketURL() (string, time.Time) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	return c.WebSocketURL
+	return c.WebSocketURL, c.WebSocketURLSaved
 }
 
-func (c *Cache) SetWebSocketURL(url string) error {
+// SetWebSocketURL caches a WebSocket URL along with when it was received.
+func (c *Cache) SetWebSocketURL(url string, saved time.Time) error {
 	c.mu.Lock()
 	c.WebSocketURL = url
+	c.WebSocketURLSaved = saved
+	c.mu.Unlock()
+	return c.save()
+}
+
+// ClearWebSocketURL forgets the cached WebSocket URL.
+func (c *Cache) ClearWebSocketURL() error {
+	c.mu.Lock()
+	c.WebSocketURL = ""
+	c.WebSocketURLSaved = time.Time{}
 	c.mu.Unlock()
 	return c.save()
 }
+
+// Usage is the accumulated active time that the active-time limits are
+// checked against.
+type Usage struct {
+	// Day and Week identify the periods DayActive and WeekActive count,
+	// such as "2024-06-03" and "2024-W23".
+	Day        string        `json:"day"`
+	DayActive  time.Duration `json:"day_active"`
+	Week       string        `json:"week"`
+	WeekActive time.Duration `json:"week_active"`
+	// SessionStart is the start of the current continuous session and
+	// LastActive the last time active time was counted up to.
+	SessionStart time.Time `json:"session_start"`
+	LastActive   time.Time `json:"last_active"`
+	Connected    bool      `json:"connected"`
+}
+
+// GetOverride returns the stored override, or nil if there is none. The
+// override is kept in its own file and read on every call, so overrides
+// set by another process are picked up.
+func (c *Cache) GetOverride() (*Override, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	data, err := os.ReadFile(c.overrideFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read override: %v", err)
+	}
+
+	var override Override
+	if err := json.Unmarshal(data, &override); err != nil {
+		return nil, fmt.Errorf("failed to parse override: %v", err)
+	}
+	return &override, nil
+}
+
+func (c *Cache) SetOverride(override Override) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return writeJSON(c.overrideFile, override)
+}
+
+func (c *Cache) ClearOverride() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.Remove(c.overrideFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// GetUsage returns the stored active time, or nil if none was stored yet.
+func (c *Cache) GetUsage() (*Usage, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	data, err := os.ReadFile(c.usageFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage: %v", err)
+	}
+
+	var usage Usage
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return nil, fmt.Errorf("failed to parse usage: %v", err)
+	}
+	return &usage, nil
+}
+
+func (c *Cache) SetUsage(usage Usage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return writeJSON(c.usageFile, usage)
+}
+
+// writeJSON writes v to a temporary file first and then renames it, so
+// readers never see a partial file.
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %v", filepath.Base(path), err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
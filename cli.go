@@ -0,0 +1,237 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/lucy/slack-always-active/cache"
+	"github.com/lucy/slack-always-active/schedule"
+)
+
+const usage = `Usage:
+  slack-always-active                          run and keep Slack active during working hours
+  slack-always-active schedule preview [-n N] [-from TIME]
+                                               list the next N active windows
+  slack-always-active schedule explain [-at TIME]
+                                               explain why a time is active or inactive
+  slack-always-active schedule export [-weeks N] [-o FILE]
+                                               write the next N weeks as an iCalendar feed
+  slack-always-active override active UNTIL    stay active until UNTIL, whatever the schedule says
+  slack-always-active override away UNTIL      stay away until UNTIL
+  slack-always-active override clear           follow the schedule again
+  slack-always-active override status          show the override in effect
+
+TIME is "2006-01-02 15:04", "2006-01-02T15:04:05", RFC 3339 or "15:04" for today,
+read in the schedule time zone unless it carries an offset. It defaults to now.
+UNTIL is a TIME or a duration from now such as "2h" or "45m"; "15:04" means the
+next time the clock shows 15:04.
+`
+
+// runCommand runs a CLI subcommand. Subcommands only read the schedule
+// settings, so they work without Slack credentials.
+func runCommand(args []string, out io.Writer) error {
+	if len(args) < 2 || (args[0] != "schedule" && args[0] != "override") {
+		return fmt.Errorf("unknown command\n\n%s", usage)
+	}
+
+	sched, err := schedule.NewSchedule()
+	if err != nil {
+		return fmt.Errorf("failed to initialize schedule: %v", err)
+	}
+
+	// Apply the override the running process follows
+	store, err := cache.NewCache(cacheDir)
+	if err != nil {
+		return fmt.Errorf("failed to initialize cache: %v", err)
+	}
+	if err := sched.SetOverrideStore(store); err != nil {
+		return fmt.Errorf("failed to load override: %v", err)
+	}
+
+	if args[0] == "override" {
+		return runOverride(sched, args[1:], out)
+	}
+
+	switch args[1] {
+	case "preview":
+		return runPreview(sched, args[2:], out)
+	case "explain":
+		return runExplain(sched, args[2:], out)
+	case "export":
+		return runExport(sched, args[2:], out)
+	default:
+		return fmt.Errorf("unknown schedule command %q\n\n%s", args[1], usage)
+	}
+}
+
+func runPreview(sched *schedule.Schedule, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("schedule preview", flag.ContinueOnError)
+	count := flags.Int("n", 10, "number of windows to list")
+	fromStr := flags.String("from", "", "list windows from this time")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	from, err := parseCommandTime(*fromStr, sched.Location(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	periods := sched.Preview(from, *count)
+	if len(periods) == 0 {
+		fmt.Fprintln(out, "No upcoming active windows")
+		return nil
+	}
+	for _, p := range periods {
+		fmt.Fprintf(out, "%s  (%s)\n", formatPeriod(p, sched.Location()), formatPeriod(p, time.UTC))
+	}
+	return nil
+}
+
+func runExplain(sched *schedule.Schedule, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("schedule explain", flag.ContinueOnError)
+	atStr := flags.String("at", "", "time to explain")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	at, err := parseCommandTime(*atStr, sched.Location(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(out, sched.Explain(at))
+	return nil
+}
+
+func runExport(sched *schedule.Schedule, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("schedule export", flag.ContinueOnError)
+	weeks := flags.Int("weeks", defaultExportWeeks, "number of weeks to export")
+	output := flags.String("o", "", "write to this file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *weeks < 1 || *weeks > maxExportWeeks {
+		return fmt.Errorf("weeks must be between 1 and %d", maxExportWeeks)
+	}
+
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %v", *output, err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	from, until := exportRange(sched, time.Now(), *weeks)
+	return sched.WriteCalendar(out, from, until, calendarSummary)
+}
+
+func runOverride(sched *schedule.Schedule, args []string, out io.Writer) error {
+	switch args[0] {
+	case "active", "away":
+		if len(args) != 2 {
+			return fmt.Errorf("override %s needs an end time\n\n%s", args[0], usage)
+		}
+		until, err := parseUntil(args[1], sched.Location(), time.Now())
+		if err != nil {
+			return err
+		}
+		if args[0] == "active" {
+			err = sched.ForceActiveUntil(until)
+		} else {
+			err = sched.ForceAwayUntil(until)
+		}
+		if err != nil {
+			return err
+		}
+	case "clear":
+		if err := sched.ClearOverride(); err != nil {
+			return err
+		}
+	case "status":
+	default:
+		return fmt.Errorf("unknown override command %q\n\n%s", args[0], usage)
+	}
+
+	if o, ok := sched.ActiveOverride(); ok {
+		fmt.Fprintf(out, "Override: %s until %s\n", o.Mode, formatTimeInLocation(o.Until, sched.Location()))
+	} else {
+		fmt.Fprintln(out, "No override, following the schedule")
+	}
+	if next := sched.NextTransition(); !next.IsZero() {
+		state := "active"
+		if sched.IsWorkingTime() {
+			state = "away"
+		}
+		fmt.Fprintf(out, "Next change: %s at %s\n", state, formatTimeInLocation(next, sched.Location()))
+	}
+	return nil
+}
+
+// parseUntil parses the end of an override: a duration from now, a time of
+// day that is the next one after now, or a TIME.
+func parseUntil(value string, loc *time.Location, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(d), nil
+	}
+	if t, err := time.ParseInLocation("15:04", value, loc); err == nil {
+		local := now.In(loc)
+		until := time.Date(local.Year(), local.Month(), local.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+		if !until.After(now) {
+			until = time.Date(local.Year(), local.Month(), local.Day()+1, t.Hour(), t.Minute(), 0, 0, loc)
+		}
+		return until, nil
+	}
+	if value == "" {
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	return parseCommandTime(value, loc, now)
+}
+
+// parseCommandTime parses a TIME argument; an empty string means now, and
+// a time of day means that time today.
+func parseCommandTime(value string, loc *time.Location, now time.Time) (time.Time, error) {
+	if value == "" {
+		return now, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.ParseInLocation("15:04", value, loc); err == nil {
+		local := now.In(loc)
+		return time.Date(local.Year(), local.Month(), local.Day(), t.Hour(), t.Minute(), 0, 0, loc), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+func formatPeriod(p schedule.Period, loc *time.Location) string {
+	start := p.Start.In(loc)
+	if p.End.IsZero() {
+		return fmt.Sprintf("%s - open end", start.Format("Mon 2006-01-02 15:04 MST"))
+	}
+	end := p.End.In(loc)
+	endLayout := "15:04 MST"
+	if end.YearDay() != start.YearDay() || end.Year() != start.Year() {
+		endLayout = "Mon 2006-01-02 15:04 MST"
+	}
+	return fmt.Sprintf("%s - %s", start.Format("Mon 2006-01-02 15:04"), end.Format(endLayout))
+}
+
+// commandMain runs a subcommand and exits.
+func commandMain(args []string) {
+	if err := runCommand(args, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	os.Exit(0)
+}
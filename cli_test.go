@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseUntil(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skipf("time zone Asia/Kolkata not available: %v", err)
+	}
+	// 01:30 on Tuesday in Kolkata, still Monday in UTC
+	now := time.Date(2024, 6, 3, 20, 0, 0, 0, time.UTC)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 6, day, hour, minute, 0, 0, kolkata)
+	}
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"90m", now.Add(90 * time.Minute)},
+		{"09:00", at(4, 9, 0)},
+		// Times of day that already passed are tomorrow's
+		{"01:00", at(5, 1, 0)},
+		{"01:30", at(5, 1, 30)},
+		{"2024-06-10 18:00", at(10, 18, 0)},
+		{"2024-06-10T18:00:00Z", time.Date(2024, 6, 10, 18, 0, 0, 0, time.UTC)},
+		{"2024-06-10", at(10, 0, 0)},
+	}
+	for _, tt := range tests {
+		got, err := parseUntil(tt.value, kolkata, now)
+		if err != nil {
+			t.Errorf("parseUntil(%q): %v", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseUntil(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "tomorrow", "25:00", "2024-13-01", "10"} {
+		if _, err := parseUntil(bad, kolkata, now); err == nil {
+			t.Errorf("parseUntil(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestParseCommandTime(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skipf("time zone Asia/Kolkata not available: %v", err)
+	}
+	now := time.Date(2024, 6, 3, 20, 0, 0, 0, time.UTC)
+	at := func(day, hour, minute, second int) time.Time {
+		return time.Date(2024, 6, day, hour, minute, second, 0, kolkata)
+	}
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"", now},
+		// A time of day is today's in the schedule location, even if it
+		// passed
+		{"00:30", at(4, 0, 30, 0)},
+		{"2024-06-10 18:00:30", at(10, 18, 0, 30)},
+		{"2024-06-10 18:00", at(10, 18, 0, 0)},
+		{"2024-06-10T18:00:30", at(10, 18, 0, 30)},
+		{"2024-06-10T18:00", at(10, 18, 0, 0)},
+		{"2024-06-10", at(10, 0, 0, 0)},
+		{"2024-06-10T18:00:00+02:00", time.Date(2024, 6, 10, 16, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseCommandTime(tt.value, kolkata, now)
+		if err != nil {
+			t.Errorf("parseCommandTime(%q): %v", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseCommandTime(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"1h", "now", "2024-06-10 25:00", "06/10/2024"} {
+		if _, err := parseCommandTime(bad, kolkata, now); err == nil {
+			t.Errorf("parseCommandTime(%q) succeeded, want error", bad)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package clock
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a Clock whose time only moves when Advance or Set is called.
+// Timers and tickers fire in order as the time passes their deadlines.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// fakeWaiter is a pending timer or ticker.
+type fakeWaiter struct {
+	fake     *Fake
+	deadline time.Time
+	period   time.Duration
+	ch       chan time.Time
+}
+
+// NewFake returns a Fake set to the given time.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+func (f *Fake) Until(t time.Time) time.Duration {
+	return t.Sub(f.Now())
+}
+
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return fakeTimer{f.schedule(d, 0)}
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.schedule(d, d)}
+}
+
+func (f *Fake) schedule(d, period time.Duration) *fakeWaiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &fakeWaiter{
+		fake:     f,
+		deadline: f.now.Add(d),
+		period:   period,
+		ch:       make(chan time.Time, 1),
+	}
+	if d <= 0 && period == 0 {
+		// Expired timers fire right away, as with the time package
+		w.ch <- f.now
+		return w
+	}
+	f.add(w)
+	return w
+}
+
+// add registers a waiter; f.mu must be held.
+func (f *Fake) add(w *fakeWaiter) {
+	f.waiters = append(f.waiters, w)
+}
+
+// remove unregisters a waiter and reports whether it was pending; f.mu must
+// be held.
+func (f *Fake) remove(w *fakeWaiter) bool {
+	for i, other := range f.waiters {
+		if other == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the time forward by d, firing every timer and ticker whose
+// deadline is reached along the way.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the time forward to t. It never moves the time backwards.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for {
+		sort.SliceStable(f.waiters, func(i, j int) bool {
+			return f.waiters[i].deadline.Before(f.waiters[j].deadline)
+		})
+		if len(f.waiters) == 0 || f.waiters[0].deadline.After(t) {
+			break
+		}
+
+		w := f.waiters[0]
+		if w.deadline.After(f.now) {
+			f.now = w.deadline
+		}
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+		} else {
+			f.remove(w)
+		}
+
+		// Like the time package, drop the tick if the last one was not received
+		select {
+		case w.ch <- f.now:
+		default:
+		}
+	}
+
+	if t.After(f.now) {
+		f.now = t
+	}
+}
+
+// Waiters returns the number of pending timers and tickers.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+// BlockUntil waits until at least n timers and tickers are pending with no
+// tick left to receive. Tests use it to let the code under test go back to
+// sleep before advancing.
+func (f *Fake) BlockUntil(n int) {
+	for f.blocked() < n {
+		runtime.Gosched()
+	}
+}
+
+// blocked returns the number of pending timers and tickers whose last tick
+// was received.
+func (f *Fake) blocked() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, w := range f.waiters {
+		if len(w.ch) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (w *fakeWaiter) C() <-chan time.Time {
+	return w.ch
+}
+
+func (w *fakeWaiter) stop() bool {
+	w.fake.mu.Lock()
+	defer w.fake.mu.Unlock()
+	return w.fake.remove(w)
+}
+
+func (w *fakeWaiter) reset(d time.Duration) bool {
+	w.fake.mu.Lock()
+	defer w.fake.mu.Unlock()
+
+	pending := w.fake.remove(w)
+	w.deadline = w.fake.now.Add(d)
+	if w.period > 0 {
+		w.period = d
+	}
+	w.fake.add(w)
+	return pending
+}
+
+type fakeTimer struct {
+	*fakeWaiter
+}
+
+func (t fakeTimer) Stop() bool {
+	return t.stop()
+}
+
+func (t fakeTimer) Reset(d time.Duration) bool {
+	return t.reset(d)
+}
+
+type fakeTicker struct {
+	*fakeWaiter
+}
+
+func (t fakeTicker) Stop() {
+	t.stop()
+}
+
+func (t fakeTicker) Reset(d time.Duration) {
+	t.reset(d)
+}
@@ -0,0 +1,65 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeFiresInOrder(t *testing.T) {
+	start := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+
+	timer := f.NewTimer(90 * time.Second)
+	ticker := f.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	f.Advance(time.Minute)
+	select {
+	case got := <-ticker.C():
+		if want := start.Add(time.Minute); !got.Equal(want) {
+			t.Errorf("tick at %s, want %s", got, want)
+		}
+	default:
+		t.Fatal("ticker did not fire")
+	}
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired early")
+	default:
+	}
+
+	f.Advance(time.Minute)
+	select {
+	case got := <-timer.C():
+		if want := start.Add(90 * time.Second); !got.Equal(want) {
+			t.Errorf("timer fired at %s, want %s", got, want)
+		}
+	default:
+		t.Fatal("timer did not fire")
+	}
+	if timer.Stop() {
+		t.Error("Stop on a fired timer reported it pending")
+	}
+	if got := f.Waiters(); got != 1 {
+		t.Errorf("Waiters() = %d, want 1", got)
+	}
+}
+
+func TestFakeSleep(t *testing.T) {
+	f := NewFake(time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC))
+	done := make(chan struct{})
+	go func() {
+		f.Sleep(time.Hour)
+		close(done)
+	}()
+
+	f.BlockUntil(1)
+	f.Advance(59 * time.Minute)
+	select {
+	case <-done:
+		t.Fatal("Sleep returned early")
+	default:
+	}
+	f.Advance(time.Minute)
+	<-done
+}
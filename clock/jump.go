@@ -0,0 +1,55 @@
+package clock
+
+import "time"
+
+// Jump is a gap in time noticed between two checks of a JumpDetector.
+type Jump struct {
+	// From is the time of the last check before the jump and To the time
+	// of the check that noticed it.
+	From, To time.Time
+	// Wall and Monotonic are the time that passed in between on the wall
+	// clock and on the monotonic clock.
+	Wall, Monotonic time.Duration
+}
+
+// JumpDetector notices when the host was suspended and resumed, or when
+// the wall clock was set. The monotonic clock stops while the host is
+// suspended but the wall clock does not, so the two disagree after a
+// resume. Clocks without a monotonic reading, such as Fake, are detected
+// by a gap between checks that is much longer than expected.
+type JumpDetector struct {
+	// interval is the longest time expected between two checks, and
+	// threshold the difference that counts as a jump.
+	interval  time.Duration
+	threshold time.Duration
+	last      time.Time
+}
+
+// NewJumpDetector returns a detector for checks made at least every
+// interval.
+func NewJumpDetector(interval, threshold time.Duration) *JumpDetector {
+	return &JumpDetector{interval: interval, threshold: threshold}
+}
+
+// Check records now and reports whether time jumped since the last check.
+func (d *JumpDetector) Check(now time.Time) (Jump, bool) {
+	last := d.last
+	d.last = now
+	if last.IsZero() {
+		return Jump{}, false
+	}
+
+	// Sub uses the monotonic readings when both times have one; Round(0)
+	// strips them
+	jump := Jump{
+		From:      last,
+		To:        now,
+		Wall:      now.Round(0).Sub(last.Round(0)),
+		Monotonic: now.Sub(last),
+	}
+	drift := jump.Wall - jump.Monotonic
+	if drift > d.threshold || drift < -d.threshold || jump.Monotonic < 0 || jump.Monotonic > d.interval+d.threshold {
+		return jump, true
+	}
+	return Jump{}, false
+}
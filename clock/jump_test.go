@@ -0,0 +1,44 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJumpDetector(t *testing.T) {
+	d := NewJumpDetector(10*time.Second, time.Minute)
+	start := time.Now()
+
+	if _, jumped := d.Check(start); jumped {
+		t.Error("the first check cannot notice a jump")
+	}
+	if _, jumped := d.Check(start.Add(10 * time.Second)); jumped {
+		t.Error("a regular check was taken for a jump")
+	}
+	if _, jumped := d.Check(start.Add(50 * time.Second)); jumped {
+		t.Error("a late check within the threshold was taken for a jump")
+	}
+}
+
+func TestJumpDetectorWithoutMonotonicClock(t *testing.T) {
+	clk := NewFake(time.Date(2024, 6, 3, 22, 0, 0, 0, time.UTC))
+	d := NewJumpDetector(10*time.Second, time.Minute)
+	d.Check(clk.Now())
+
+	clk.Advance(time.Minute)
+	if _, jumped := d.Check(clk.Now()); jumped {
+		t.Error("a minute between checks is not a jump")
+	}
+
+	// Suspended overnight
+	clk.Advance(8 * time.Hour)
+	jump, jumped := d.Check(clk.Now())
+	if !jumped || jump.Wall != 8*time.Hour || !jump.From.Equal(time.Date(2024, 6, 3, 22, 1, 0, 0, time.UTC)) {
+		t.Errorf("Check = %+v, %v, want an 8h jump from 22:01", jump, jumped)
+	}
+
+	// The clock was set back
+	if jump, jumped := d.Check(clk.Now().Add(-time.Hour)); !jumped || jump.Wall != -time.Hour {
+		t.Errorf("Check = %+v, %v, want a jump back by 1h", jump, jumped)
+	}
+}
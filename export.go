@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lucy/slack-always-active/logger"
+	"github.com/lucy/slack-always-active/schedule"
+)
+
+const (
+	defaultExportWeeks = 4
+	maxExportWeeks     = 52
+	calendarSummary    = "Slack active"
+)
+
+// exportRange returns the range of an export over the given number of
+// weeks. It starts at midnight today, so windows that are in progress are
+// exported in full.
+func exportRange(sched *schedule.Schedule, now time.Time, weeks int) (time.Time, time.Time) {
+	local := now.In(sched.Location())
+	from := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, sched.Location())
+	return from, from.AddDate(0, 0, 7*weeks)
+}
+
+// calendarHandler serves the planned schedule as an iCalendar feed. The
+// number of weeks can be set with the weeks query parameter.
+func calendarHandler(sched *schedule.Schedule) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		weeks := defaultExportWeeks
+		if weeksStr := r.URL.Query().Get("weeks"); weeksStr != "" {
+			n, err := strconv.Atoi(weeksStr)
+			if err != nil || n < 1 || n > maxExportWeeks {
+				http.Error(w, fmt.Sprintf("weeks must be between 1 and %d", maxExportWeeks), http.StatusBadRequest)
+				return
+			}
+			weeks = n
+		}
+
+		from, until := exportRange(sched, time.Now(), weeks)
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		if err := sched.WriteCalendar(w, from, until, calendarSummary); err != nil {
+			logger.Error("Failed to write calendar feed: %v", err)
+		}
+	})
+}
+
+// serveCalendar serves the calendar feed at /schedule.ics on addr until ctx
+// is cancelled.
+func serveCalendar(ctx context.Context, addr string, sched *schedule.Schedule) {
+	mux := http.NewServeMux()
+	mux.Handle("/schedule.ics", calendarHandler(sched))
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("Serving the schedule calendar at http://%s/schedule.ics", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Error("Failed to serve the schedule calendar: %v", err)
+	}
+}
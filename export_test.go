@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lucy/slack-always-active/schedule"
+)
+
+func TestCalendarHandler(t *testing.T) {
+	sched, err := schedule.NewScheduleFromConfig(&schedule.Config{WorkHours: "mon-sun 09:00-17:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := calendarHandler(sched)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/schedule.ics?weeks=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/calendar") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	// Every day of two weeks has a window
+	if n := strings.Count(rec.Body.String(), "BEGIN:VEVENT"); n != 14 {
+		t.Errorf("got %d events, want 14", n)
+	}
+
+	for _, query := range []string{"weeks=0", "weeks=100", "weeks=two"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/schedule.ics?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, rec.Code)
+		}
+	}
+}
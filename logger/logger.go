@@ -8,10 +8,11 @@ import (
 	"path/filepath"
 )
 
+// Until Init is called, messages only go to stdout
 var (
-	infoLogger  *log.Logger
-	errorLogger *log.Logger
-	warnLogger  *log.Logger
+	infoLogger  = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime)
+	errorLogger = log.New(os.Stdout, "ERROR: ", log.Ldate|log.Ltime)
+	warnLogger  = log.New(os.Stdout, "WARN: ", log.Ldate|log.Ltime)
 	logFile     *os.File
 )
 
@@ -9,12 +9,14 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/lucy/slack-always-active/cache"
+	"github.com/lucy/slack-always-active/clock"
 	"github.com/lucy/slack-always-active/logger"
 	"github.com/lucy/slack-always-active/schedule"
 	"github.com/lucy/slack-always-active/slackws"
@@ -86,16 +88,78 @@ func checkSlackStatus(token, cookie string) (*UserBootResponse, error) {
 	return &userBoot, nil
 }
 
-func formatTimeWithOffset(t time.Time, offset int) string {
-	// Adjust the time by the GMT offset
-	adjustedTime := t.Add(time.Duration(offset) * time.Hour)
+// cacheDir holds the WebSocket URL cache and the schedule override.
+const cacheDir = "cache/cache"
+
+// pongSettings reads MAX_MISSED_PONGS and PONG_TIMEOUT. Unset values are
+// returned as zero, which keeps the defaults.
+func pongSettings() (int, time.Duration, error) {
+	var missed int
+	var timeout time.Duration
+	if v := os.Getenv("MAX_MISSED_PONGS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("invalid MAX_MISSED_PONGS: %s", v)
+		}
+		missed = n
+	}
+	if v := os.Getenv("PONG_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return 0, 0, fmt.Errorf("invalid PONG_TIMEOUT: %s", v)
+		}
+		timeout = d
+	}
+	return missed, timeout, nil
+}
+
+// helloTimeoutSetting reads HELLO_TIMEOUT. Unset, it is returned as zero,
+// which keeps the default.
+func helloTimeoutSetting() (time.Duration, error) {
+	v := os.Getenv("HELLO_TIMEOUT")
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid HELLO_TIMEOUT: %s", v)
+	}
+	return d, nil
+}
+
+// rotationSetting reads ROTATION_INTERVAL and reports whether it is set.
+// "0" and "off" disable rotation.
+func rotationSetting() (time.Duration, bool, error) {
+	v := os.Getenv("ROTATION_INTERVAL")
+	switch v {
+	case "":
+		return 0, false, nil
+	case "0", "off":
+		return 0, true, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, false, fmt.Errorf("invalid ROTATION_INTERVAL: %s", v)
+	}
+	return d, true, nil
+}
+
+func formatTimeInLocation(t time.Time, loc *time.Location) string {
+	// Convert the time to the schedule location
+	localTime := t.In(loc)
 
-	// Format the time with the offset
-	offsetStr := fmt.Sprintf("GMT%+d", offset)
-	return fmt.Sprintf("%s (%s)", adjustedTime.Format("2006-01-02 15:04:05"), offsetStr)
+	// Format the time with the zone abbreviation and offset
+	return fmt.Sprintf("%s (%s)", localTime.Format("2006-01-02 15:04:05"), localTime.Format("MST -07:00"))
 }
 
 func main() {
+	// Subcommands only need the schedule settings and print to stdout
+	if len(os.Args) > 1 {
+		// A missing .env file is fine, the settings may come from the environment
+		_ = godotenv.Load()
+		commandMain(os.Args[1:])
+	}
+
 	// Initialize logger
 	if err := logger.Init("logs/slack-always-active.log"); err != nil {
 		log.Fatalf("Failed to initialize logger: %v", err)
@@ -124,16 +188,28 @@ func main() {
 	}
 
 	// Initialize cache
-	cache, err := cache.NewCache("cache/cache")
+	cache, err := cache.NewCache(cacheDir)
 	if err != nil {
-		logger.Error("Failed to initialize cache:", err)
+		logger.Error("Failed to initialize cache: %v", err)
 		os.Exit(1)
 	}
 
 	// Initialize schedule
-	schedule, err := schedule.NewSchedule()
+	sched, err := schedule.NewSchedule()
+	if err != nil {
+		logger.Error("Failed to initialize schedule: %v", err)
+		os.Exit(1)
+	}
+
+	// Keep overrides across restarts
+	if err := sched.SetOverrideStore(cache); err != nil {
+		logger.Error("Failed to load schedule override: %v", err)
+	}
+
+	// Keep active time within the configured limits
+	guard, err := schedule.NewGuard(sched, cache)
 	if err != nil {
-		logger.Error("Failed to initialize schedule:", err)
+		logger.Error("Failed to load active time: %v", err)
 		os.Exit(1)
 	}
 
@@ -147,6 +223,32 @@ func main() {
 
 	// Create WebSocket instance
 	ws := slackws.NewSlackWebSocket(token, cookie, cache)
+	missed, timeout, err := pongSettings()
+	if err != nil {
+		logger.Error("Invalid connection settings: %v", err)
+		os.Exit(1)
+	}
+	ws.SetPongTimeout(missed, timeout)
+	hello, err := helloTimeoutSetting()
+	if err != nil {
+		logger.Error("Invalid connection settings: %v", err)
+		os.Exit(1)
+	}
+	ws.SetHelloTimeout(hello)
+	rotation, set, err := rotationSetting()
+	if err != nil {
+		logger.Error("Invalid connection settings: %v", err)
+		os.Exit(1)
+	}
+	if set {
+		ws.SetRotationInterval(rotation)
+	}
+
+	// Reload the schedule file when it changes
+	go sched.WatchConfig(ctx, 5*time.Second)
+
+	// Pick up overrides set from the command line and expire old ones
+	go sched.WatchOverrides(ctx, 5*time.Second)
 
 	// Start a goroutine to handle signals
 	go func() {
@@ -155,64 +257,23 @@ func main() {
 		cancel()
 	}()
 
-	// Start a goroutine to check working hours and manage WebSocket connection
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				if schedule.IsWorkingTime() {
-					// If we're in working hours, ensure WebSocket is connected
-					if !ws.IsConnected() {
-						logger.Info("Working hours started, connecting to Slack...")
-						if err := ws.Connect(); err != nil {
-							logger.Error("Failed to connect to Slack:", err)
-							continue
-						}
-					}
-				} else {
-					// If we're outside working hours, disconnect WebSocket
-					if ws.IsConnected() {
-						logger.Info("Working hours ended, disconnecting from Slack...")
-						ws.Disconnect()
-						logger.Info("Disconnected from Slack")
-					}
-					nextTime := schedule.GetNextWorkingTime()
-					logger.Info(fmt.Sprintf("Outside working hours. Next working time: %s (GMT+%d)", nextTime.Format("2006-01-02 15:04:05"), schedule.GetOffset()))
-				}
-				// Check every minute
-				time.Sleep(time.Minute)
-			}
-		}
-	}()
+	// Serve the planned schedule as a calendar feed if asked to
+	if addr := os.Getenv("CALENDAR_ADDR"); addr != "" {
+		go serveCalendar(ctx, addr, sched)
+	}
 
-	// Start reading messages in a separate goroutine
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				if ws.IsConnected() {
-					if err := ws.ReadMessages(); err != nil {
-						if !strings.Contains(err.Error(), "connection is closed") {
-							logger.Error("Error reading messages:", err)
-						}
-						// Don't disconnect here, let the working hours check handle reconnection
-					}
-				}
-				time.Sleep(time.Second)
-			}
-		}
-	}()
+	// Keep the connection up while it is wanted, dialing again with backoff
+	// when it fails or drops. Disconnecting cancels the connection's
+	// context, so schedule changes and shutdown take effect at once.
+	conn := newSlackSession(ctx, ws)
+
+	// Start a goroutine to follow working hours and manage WebSocket connection
+	go supervise(ctx, sched, conn, guard, clock.Real)
 
 	// Wait for context cancellation
 	<-ctx.Done()
 
 	// Cleanup
-	if ws.IsConnected() {
-		ws.Disconnect()
-	}
+	conn.Disconnect()
 	logger.Info("Application shutdown complete")
 }
@@ -0,0 +1,683 @@
+package schedule
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lucy/slack-always-active/logger"
+)
+
+// maxPeriods bounds how many periods of a recurring event are expanded
+// from the one a search starts in.
+const maxPeriods = 10000
+
+// Calendar is a set of non-working periods, such as public holidays or
+// vacations, read from iCalendar (.ics) files.
+type Calendar struct {
+	events []event
+}
+
+// event is a single VEVENT. All-day events span whole days in the schedule
+// location; timed events use the zone they were written in.
+type event struct {
+	uid     string
+	summary string
+	start   time.Time
+	end     time.Time
+	allDay  bool
+	rule    *recurrence
+	// exdates are the occurrences that were cancelled or moved
+	exdates []exdate
+}
+
+// exdate is an excluded occurrence. A date without a time excludes the
+// occurrence on that day.
+type exdate struct {
+	at     time.Time
+	allDay bool
+}
+
+// recurrence is the supported subset of an RRULE: FREQ, INTERVAL, COUNT,
+// UNTIL and WKST, and BYDAY with plain weekdays for weekly and daily rules.
+// BYMONTH and BYMONTHDAY are accepted when they repeat the start date.
+type recurrence struct {
+	freq     string
+	interval int
+	count    int
+	until    time.Time
+	byDay    []time.Weekday
+	wkst     time.Weekday
+	// byMonth and byMonthDay are checked against the start date
+	byMonth    []int
+	byMonthDay []int
+}
+
+// unsupportedError reports valid iCalendar that is not supported. Events
+// using it are skipped rather than failing the whole calendar.
+type unsupportedError string
+
+func (e unsupportedError) Error() string {
+	return string(e)
+}
+
+// LoadCalendar reads and merges the events of the given .ics files. Dates
+// without a time zone are interpreted in loc.
+func LoadCalendar(loc *time.Location, paths ...string) (*Calendar, error) {
+	calendar := &Calendar{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open calendar: %v", err)
+		}
+		events, err := parseCalendar(file, loc)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse calendar %s: %v", path, err)
+		}
+		calendar.events = append(calendar.events, events...)
+	}
+	return calendar, nil
+}
+
+// blockedUntil reports whether t falls within an event and, if so, returns
+// the latest end among the events covering t.
+func (c *Calendar) blockedUntil(t time.Time) (time.Time, bool) {
+	var until time.Time
+	if c == nil {
+		return until, false
+	}
+	for _, e := range c.events {
+		if i, ok := e.occurrenceAt(t); ok && i.end.After(until) {
+			until = i.end
+		}
+	}
+	return until, !until.IsZero()
+}
+
+// eventAt returns the summary and span of an event covering t.
+func (c *Calendar) eventAt(t time.Time) (string, interval, bool) {
+	if c == nil {
+		return "", interval{}, false
+	}
+	for _, e := range c.events {
+		if i, ok := e.occurrenceAt(t); ok {
+			return e.summary, i, true
+		}
+	}
+	return "", interval{}, false
+}
+
+// nextBlockStart returns the earliest start of an event after t, or the
+// zero time if there is none.
+func (c *Calendar) nextBlockStart(t time.Time) time.Time {
+	var earliest time.Time
+	if c == nil {
+		return earliest
+	}
+	for _, e := range c.events {
+		if start := e.nextStart(t); !start.IsZero() && (earliest.IsZero() || start.Before(earliest)) {
+			earliest = start
+		}
+	}
+	return earliest
+}
+
+// nextStart returns the start of the first occurrence of the event after t.
+func (e event) nextStart(t time.Time) time.Time {
+	var next time.Time
+	e.occurrences(t, func(i interval) bool {
+		if i.start.After(t) {
+			next = i.start
+			return false
+		}
+		return true
+	})
+	return next
+}
+
+// occurrenceAt returns the occurrence of the event that contains t.
+func (e event) occurrenceAt(t time.Time) (interval, bool) {
+	if t.Before(e.start) {
+		return interval{}, false
+	}
+
+	var found interval
+	ok := false
+	e.occurrences(t, func(i interval) bool {
+		if i.start.After(t) {
+			return false
+		}
+		if i.contains(t) {
+			found, ok = i, true
+			return false
+		}
+		return true
+	})
+	return found, ok
+}
+
+// occurrences calls fn in order with each occurrence of the event that may
+// still be running at from or starts later, until fn returns false. Some
+// earlier occurrences may be passed too. Excluded occurrences are skipped,
+// but count towards COUNT.
+func (e event) occurrences(from time.Time, fn func(interval) bool) {
+	if e.rule == nil {
+		if !e.excluded(e.start) {
+			fn(interval{start: e.start, end: e.end})
+		}
+		return
+	}
+
+	n := 0
+	first := e.firstPeriod(from)
+	for period := first; period < first+maxPeriods; period++ {
+		for _, i := range e.period(period) {
+			if i.start.Before(e.start) {
+				continue
+			}
+			if e.rule.count > 0 && n >= e.rule.count {
+				return
+			}
+			if !e.rule.until.IsZero() && i.start.After(e.rule.until) {
+				return
+			}
+			n++
+			if e.excluded(i.start) {
+				continue
+			}
+			if !fn(i) {
+				return
+			}
+		}
+	}
+}
+
+// firstPeriod returns the period of a recurring event to start expanding
+// it from to find the occurrences running at t or later. It is a period
+// or so early, since periods are not all the same length. COUNT needs
+// every occurrence from the start, so such events start at the first
+// period; they end soon enough.
+func (e event) firstPeriod(t time.Time) int {
+	if e.rule.count > 0 {
+		return 0
+	}
+	// An occurrence running at t started at most its length before
+	t = t.Add(-e.end.Sub(e.start)).In(e.start.Location())
+	if !t.After(e.start) {
+		return 0
+	}
+
+	var n int
+	switch e.rule.freq {
+	case "YEARLY":
+		n = t.Year() - e.start.Year()
+	case "MONTHLY":
+		n = (t.Year()-e.start.Year())*12 + int(t.Month()) - int(e.start.Month())
+	case "DAILY":
+		n = int(t.Sub(e.start) / (24 * time.Hour))
+	default:
+		n = int(t.Sub(e.start) / (7 * 24 * time.Hour))
+	}
+	if n = n/e.rule.interval - 1; n < 0 {
+		return 0
+	}
+	return n
+}
+
+// period returns the occurrences of a recurring event in its n-th period,
+// such as the n-th week of a weekly rule. Dates are stepped in the event's
+// own location so local times survive DST changes.
+func (e event) period(n int) []interval {
+	step := n * e.rule.interval
+	shift := func(years, months, days int) interval {
+		return interval{
+			start: e.start.AddDate(years, months, days),
+			end:   e.end.AddDate(years, months, days),
+		}
+	}
+
+	switch e.rule.freq {
+	case "YEARLY":
+		return e.monthly(12 * step)
+	case "MONTHLY":
+		return e.monthly(step)
+	case "DAILY":
+		i := shift(0, 0, step)
+		if len(e.rule.byDay) > 0 && !containsWeekday(e.rule.byDay, i.start.Weekday()) {
+			return nil
+		}
+		return []interval{i}
+	}
+
+	// Weekly: each listed day of the week, in order from WKST
+	if len(e.rule.byDay) == 0 {
+		return []interval{shift(0, 0, 7*step)}
+	}
+	offset := func(day time.Weekday) int {
+		return (int(day) - int(e.rule.wkst) + 7) % 7
+	}
+	var is []interval
+	for _, day := range e.rule.byDay {
+		is = append(is, shift(0, 0, 7*step+offset(day)-offset(e.start.Weekday())))
+	}
+	for i := 1; i < len(is); i++ {
+		for j := i; j > 0 && is[j].start.Before(is[j-1].start); j-- {
+			is[j], is[j-1] = is[j-1], is[j]
+		}
+	}
+	return is
+}
+
+// monthly returns the occurrence of a recurring event the given number of
+// months after its start. A date the month does not have, such as 31 April
+// or 29 February in a common year, has no occurrence; RFC 5545 skips it
+// rather than rolling it over into the next month.
+func (e event) monthly(months int) []interval {
+	loc := e.start.Location()
+	y, m, d := e.start.Date()
+	start := time.Date(y, m+time.Month(months), d, e.start.Hour(), e.start.Minute(), e.start.Second(), 0, loc)
+	if start.Day() != d {
+		return nil
+	}
+
+	// The end keeps its distance in days and its time of day
+	end := e.end.In(loc)
+	ey, em, ed := end.Date()
+	days := int(time.Date(ey, em, ed, 0, 0, 0, 0, time.UTC).Sub(time.Date(y, m, d, 0, 0, 0, 0, time.UTC)) / (24 * time.Hour))
+	return []interval{{
+		start: start,
+		end:   time.Date(start.Year(), start.Month(), start.Day()+days, end.Hour(), end.Minute(), end.Second(), 0, loc),
+	}}
+}
+
+// excluded reports whether the occurrence starting at start is an EXDATE.
+func (e event) excluded(start time.Time) bool {
+	for _, x := range e.exdates {
+		if x.allDay {
+			y1, m1, d1 := start.In(x.at.Location()).Date()
+			y2, m2, d2 := x.at.Date()
+			if y1 == y2 && m1 == m2 && d1 == d2 {
+				return true
+			}
+		} else if x.at.Equal(start) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsWeekday(days []time.Weekday, day time.Weekday) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// contentLines reads an iCalendar stream and unfolds continuation lines.
+func contentLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// property is a parsed content line such as
+// "DTSTART;TZID=Europe/Berlin:20240101T090000".
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+func parseProperty(line string) (property, error) {
+	// The value starts at the first colon that is not inside a quoted parameter
+	inQuotes := false
+	sep := -1
+	for i, r := range line {
+		if r == '"' {
+			inQuotes = !inQuotes
+		} else if r == ':' && !inQuotes {
+			sep = i
+			break
+		}
+	}
+	if sep < 0 {
+		return property{}, fmt.Errorf("invalid content line: %s", line)
+	}
+
+	parts := strings.Split(line[:sep], ";")
+	prop := property{
+		name:   strings.ToUpper(parts[0]),
+		params: make(map[string]string),
+		value:  line[sep+1:],
+	}
+	for _, param := range parts[1:] {
+		key, value, _ := strings.Cut(param, "=")
+		prop.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+	}
+	return prop, nil
+}
+
+func parseCalendar(r io.Reader, loc *time.Location) ([]event, error) {
+	lines, err := contentLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []event
+	var props map[string]property
+	var exdates []property
+	// moved holds the occurrences replaced by another VEVENT with a
+	// RECURRENCE-ID, by UID
+	moved := make(map[string][]exdate)
+	for _, line := range lines {
+		prop, err := parseProperty(line)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case prop.name == "BEGIN" && strings.EqualFold(prop.value, "VEVENT"):
+			props = make(map[string]property)
+			exdates = nil
+		case prop.name == "END" && strings.EqualFold(prop.value, "VEVENT"):
+			if props == nil {
+				return nil, fmt.Errorf("END:VEVENT without BEGIN:VEVENT")
+			}
+			if rid, ok := props["RECURRENCE-ID"]; ok {
+				at, allDay, err := parseDateTime(rid, loc)
+				if err != nil {
+					return nil, fmt.Errorf("event %q: %v", props["SUMMARY"].value, err)
+				}
+				uid := props["UID"].value
+				moved[uid] = append(moved[uid], exdate{at: at, allDay: allDay})
+			}
+			e, skip, err := newEvent(props, exdates, loc)
+			if err != nil {
+				return nil, err
+			}
+			if !skip {
+				events = append(events, e)
+			}
+			props = nil
+		case props != nil && prop.name == "EXDATE":
+			exdates = append(exdates, prop)
+		case props != nil:
+			// Keep the first occurrence; nested components such as VALARM
+			// come after the event's own properties
+			if _, ok := props[prop.name]; !ok {
+				props[prop.name] = prop
+			}
+		}
+	}
+
+	// A moved or cancelled occurrence no longer happens at its original time
+	for i := range events {
+		if events[i].rule != nil && events[i].uid != "" {
+			events[i].exdates = append(events[i].exdates, moved[events[i].uid]...)
+		}
+	}
+	return events, nil
+}
+
+// newEvent builds an event from VEVENT properties and its EXDATEs.
+// Cancelled events, events without a duration and events with unsupported
+// recurrence rules are skipped.
+func newEvent(props map[string]property, exdates []property, loc *time.Location) (event, bool, error) {
+	e := event{uid: props["UID"].value, summary: props["SUMMARY"].value}
+	if strings.EqualFold(props["STATUS"].value, "CANCELLED") {
+		return e, true, nil
+	}
+
+	dtstart, ok := props["DTSTART"]
+	if !ok {
+		return e, false, fmt.Errorf("event %q has no DTSTART", e.summary)
+	}
+	start, allDay, err := parseDateTime(dtstart, loc)
+	if err != nil {
+		return e, false, fmt.Errorf("event %q: %v", e.summary, err)
+	}
+	e.start = start
+	e.allDay = allDay
+
+	if dtend, ok := props["DTEND"]; ok {
+		e.end, _, err = parseDateTime(dtend, loc)
+		if err != nil {
+			return e, false, fmt.Errorf("event %q: %v", e.summary, err)
+		}
+	} else if duration, ok := props["DURATION"]; ok {
+		e.end, err = addDuration(start, duration.value)
+		if err != nil {
+			return e, false, fmt.Errorf("event %q: %v", e.summary, err)
+		}
+	} else if allDay {
+		// An all-day event without an end lasts one day
+		e.end = start.AddDate(0, 0, 1)
+	} else {
+		e.end = start
+	}
+
+	if !e.end.After(e.start) {
+		return e, true, nil
+	}
+
+	if rrule, ok := props["RRULE"]; ok {
+		e.rule, err = parseRecurrence(rrule.value, loc)
+		if err == nil {
+			err = e.rule.check(e.start)
+		}
+		if _, unsupported := err.(unsupportedError); unsupported {
+			logger.Warn("Skipping calendar event %q: %v", e.summary, err)
+			return e, true, nil
+		}
+		if err != nil {
+			return e, false, fmt.Errorf("event %q: %v", e.summary, err)
+		}
+	}
+
+	for _, prop := range exdates {
+		for _, value := range strings.Split(prop.value, ",") {
+			at, allDay, err := parseDateTime(property{params: prop.params, value: value}, loc)
+			if err != nil {
+				return e, false, fmt.Errorf("event %q: EXDATE: %v", e.summary, err)
+			}
+			e.exdates = append(e.exdates, exdate{at: at, allDay: allDay})
+		}
+	}
+
+	return e, false, nil
+}
+
+// parseDateTime parses a DATE or DATE-TIME value. UTC times end in "Z",
+// zoned times carry a TZID parameter and floating times use loc.
+func parseDateTime(prop property, loc *time.Location) (time.Time, bool, error) {
+	value := prop.value
+	if prop.params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		t, err := time.ParseInLocation("20060102", value, loc)
+		if err != nil {
+			return t, false, fmt.Errorf("invalid date: %s", value)
+		}
+		return t, true, nil
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		if err != nil {
+			return t, false, fmt.Errorf("invalid date-time: %s", value)
+		}
+		return t, false, nil
+	}
+
+	zone := loc
+	if tzid := prop.params["TZID"]; tzid != "" {
+		tzLoc, err := time.LoadLocation(tzid)
+		if err != nil {
+			// Outlook writes Windows zone names, which are usually the
+			// local zone
+			logger.Warn("Unknown time zone %q in calendar, using %s: %v", tzid, loc, err)
+		} else {
+			zone = tzLoc
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, zone)
+	if err != nil {
+		return t, false, fmt.Errorf("invalid date-time: %s", value)
+	}
+	return t, false, nil
+}
+
+// addDuration adds an iCalendar duration such as "P1D" or "PT1H30M" to t.
+// Days and weeks are added on the calendar, the rest as elapsed time.
+func addDuration(t time.Time, value string) (time.Time, error) {
+	rest, ok := strings.CutPrefix(strings.TrimPrefix(value, "+"), "P")
+	if !ok {
+		return t, fmt.Errorf("invalid duration: %s", value)
+	}
+
+	var days int
+	var elapsed time.Duration
+	inTime := false
+	for rest != "" {
+		if rest[0] == 'T' {
+			inTime = true
+			rest = rest[1:]
+			continue
+		}
+		i := strings.IndexAny(rest, "WDHMS")
+		if i <= 0 {
+			return t, fmt.Errorf("invalid duration: %s", value)
+		}
+		n, err := strconv.Atoi(rest[:i])
+		if err != nil {
+			return t, fmt.Errorf("invalid duration: %s", value)
+		}
+		switch unit := rest[i]; {
+		case unit == 'W' && !inTime:
+			days += 7 * n
+		case unit == 'D' && !inTime:
+			days += n
+		case unit == 'H' && inTime:
+			elapsed += time.Duration(n) * time.Hour
+		case unit == 'M' && inTime:
+			elapsed += time.Duration(n) * time.Minute
+		case unit == 'S' && inTime:
+			elapsed += time.Duration(n) * time.Second
+		default:
+			return t, fmt.Errorf("invalid duration: %s", value)
+		}
+		rest = rest[i+1:]
+	}
+
+	return t.AddDate(0, 0, days).Add(elapsed), nil
+}
+
+// weekdays maps the two-letter iCalendar day names to weekdays.
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+func parseRecurrence(value string, loc *time.Location) (*recurrence, error) {
+	rule := &recurrence{interval: 1, wkst: time.Monday}
+	for _, part := range strings.Split(value, ";") {
+		key, val, _ := strings.Cut(part, "=")
+		var err error
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			rule.freq = strings.ToUpper(val)
+		case "INTERVAL":
+			rule.interval, err = strconv.Atoi(val)
+			if err == nil && rule.interval < 1 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "COUNT":
+			rule.count, err = strconv.Atoi(val)
+		case "UNTIL":
+			rule.until, _, err = parseDateTime(property{value: val}, loc)
+		case "WKST":
+			day, ok := weekdays[strings.ToUpper(val)]
+			if !ok {
+				err = fmt.Errorf("unknown day")
+			}
+			rule.wkst = day
+		case "BYDAY":
+			for _, name := range strings.Split(val, ",") {
+				day, ok := weekdays[strings.ToUpper(name)]
+				if !ok {
+					// Such as "1MO" for the first Monday of the month
+					return nil, unsupportedError(fmt.Sprintf("unsupported RRULE part: %s", part))
+				}
+				rule.byDay = append(rule.byDay, day)
+			}
+		case "BYMONTH":
+			rule.byMonth, err = parseInts(val)
+		case "BYMONTHDAY":
+			rule.byMonthDay, err = parseInts(val)
+		default:
+			return nil, unsupportedError(fmt.Sprintf("unsupported RRULE part: %s", part))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE %s: %v", part, err)
+		}
+	}
+
+	switch rule.freq {
+	case "YEARLY", "MONTHLY", "WEEKLY", "DAILY":
+		return rule, nil
+	case "HOURLY", "MINUTELY", "SECONDLY":
+		return nil, unsupportedError(fmt.Sprintf("unsupported RRULE frequency: %s", rule.freq))
+	default:
+		return nil, fmt.Errorf("invalid RRULE frequency: %s", rule.freq)
+	}
+}
+
+// check reports BY* parts the rule does not support for an event starting
+// at start. BYMONTH and BYMONTHDAY are only supported when they repeat
+// the start date, as many calendar programs write them.
+func (r *recurrence) check(start time.Time) error {
+	if len(r.byDay) > 0 && r.freq != "WEEKLY" && r.freq != "DAILY" {
+		return unsupportedError(fmt.Sprintf("unsupported BYDAY in a %s RRULE", r.freq))
+	}
+	if len(r.byMonth) > 0 && (r.freq != "YEARLY" || len(r.byMonth) > 1 || r.byMonth[0] != int(start.Month())) {
+		return unsupportedError("unsupported BYMONTH in RRULE")
+	}
+	if len(r.byMonthDay) > 0 && (r.freq != "YEARLY" && r.freq != "MONTHLY" || len(r.byMonthDay) > 1 || r.byMonthDay[0] != start.Day()) {
+		return unsupportedError("unsupported BYMONTHDAY in RRULE")
+	}
+	return nil
+}
+
+// parseInts parses a comma-separated list of numbers.
+func parseInts(value string) ([]int, error) {
+	var ns []int
+	for _, field := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
@@ -0,0 +1,285 @@
+package schedule
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCalendar = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Whit Monday\r\n" +
+	"DTSTART;VALUE=DATE:20240520\r\n" +
+	"DTEND;VALUE=DATE:20240521\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Dentist\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20240522T100000\r\n" +
+	"DURATION:PT1H30M\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Vacation in the\r\n" +
+	"  mountains\r\n" +
+	"DTSTART;VALUE=DATE:20240607\r\n" +
+	"DTEND;VALUE=DATE:20240612\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Labour Day\r\n" +
+	"DTSTART;VALUE=DATE:20200501\r\n" +
+	"RRULE:FREQ=YEARLY\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Cancelled offsite\r\n" +
+	"STATUS:CANCELLED\r\n" +
+	"DTSTART:20240523T070000Z\r\n" +
+	"DTEND:20240523T160000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseCalendar(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	events, err := parseCalendar(strings.NewReader(testCalendar), berlin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("parsed %d events, want 4", len(events))
+	}
+	if events[2].summary != "Vacation in the mountains" {
+		t.Errorf("folded summary = %q", events[2].summary)
+	}
+	if want := time.Date(2024, 5, 22, 11, 30, 0, 0, berlin); !events[1].end.Equal(want) {
+		t.Errorf("DURATION end = %s, want %s", events[1].end, want)
+	}
+
+	for _, bad := range []string{
+		"BEGIN:VEVENT\nSUMMARY:No start\nEND:VEVENT\n",
+		"BEGIN:VEVENT\nDTSTART:2024\nEND:VEVENT\n",
+		"BEGIN:VEVENT\nDTSTART:20240101\nRRULE:FREQ=WEEKLY;INTERVAL=0\nEND:VEVENT\n",
+		"BEGIN:VEVENT\nDTSTART:20240101\nRRULE:FREQ=FORTNIGHTLY\nEND:VEVENT\n",
+		"BEGIN:VEVENT\nDTSTART:20240101\nRRULE:FREQ=WEEKLY\nEXDATE:2024\nEND:VEVENT\n",
+	} {
+		if _, err := parseCalendar(strings.NewReader(bad), berlin); err == nil {
+			t.Errorf("parseCalendar(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+const recurringCalendar = "BEGIN:VCALENDAR\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:gym\r\n" +
+	"SUMMARY:Gym\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20240603T120000\r\n" +
+	"DTEND;TZID=Europe/Berlin:20240603T130000\r\n" +
+	"RRULE:FREQ=WEEKLY;BYDAY=MO,WE;WKST=SU\r\n" +
+	"EXDATE;TZID=Europe/Berlin:20240605T120000\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:gym\r\n" +
+	"SUMMARY:Gym, later\r\n" +
+	"RECURRENCE-ID;TZID=Europe/Berlin:20240610T120000\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20240610T150000\r\n" +
+	"DTEND;TZID=Europe/Berlin:20240610T160000\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Monthly review\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20240603T090000\r\n" +
+	"DTEND;TZID=Europe/Berlin:20240603T100000\r\n" +
+	"RRULE:FREQ=MONTHLY;BYDAY=1MO\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Labour Day\r\n" +
+	"DTSTART;VALUE=DATE:20200501\r\n" +
+	"RRULE:FREQ=YEARLY;BYMONTH=5;BYMONTHDAY=1\r\n" +
+	"EXDATE;VALUE=DATE:20230501\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Outlook meeting\r\n" +
+	"DTSTART;TZID=W. Europe Standard Time:20240612T090000\r\n" +
+	"DTEND;TZID=W. Europe Standard Time:20240612T100000\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestCalendarRecurrence(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	events, err := parseCalendar(strings.NewReader(recurringCalendar), berlin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The monthly review uses an unsupported rule and is skipped
+	if len(events) != 4 {
+		t.Fatalf("parsed %d events, want 4", len(events))
+	}
+	calendar := &Calendar{events: events}
+
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, berlin)
+	}
+	tests := []struct {
+		name    string
+		at      time.Time
+		blocked bool
+		until   time.Time
+	}{
+		{"BYDAY monday", at(2024, 6, 3, 12, 30), true, at(2024, 6, 3, 13, 0)},
+		{"day not in BYDAY", at(2024, 6, 4, 12, 30), false, time.Time{}},
+		{"EXDATE", at(2024, 6, 5, 12, 30), false, time.Time{}},
+		{"original time of a moved occurrence", at(2024, 6, 10, 12, 30), false, time.Time{}},
+		{"moved occurrence", at(2024, 6, 10, 15, 30), true, at(2024, 6, 10, 16, 0)},
+		{"BYDAY wednesday", at(2024, 6, 12, 12, 30), true, at(2024, 6, 12, 13, 0)},
+		{"skipped unsupported rule", at(2024, 7, 1, 9, 30), false, time.Time{}},
+		{"redundant BYMONTH and BYMONTHDAY", at(2025, 5, 1, 12, 0), true, at(2025, 5, 2, 0, 0)},
+		{"all-day EXDATE", at(2023, 5, 1, 12, 0), false, time.Time{}},
+		{"unknown TZID in the schedule zone", at(2024, 6, 12, 9, 30), true, at(2024, 6, 12, 10, 0)},
+	}
+	for _, tt := range tests {
+		until, blocked := calendar.blockedUntil(tt.at)
+		if blocked != tt.blocked || !until.Equal(tt.until) {
+			t.Errorf("%s: blockedUntil(%s) = %s, %v, want %s, %v", tt.name, tt.at, until, blocked, tt.until, tt.blocked)
+		}
+	}
+
+	// Excluded and moved occurrences are not upcoming either
+	if got, want := calendar.nextBlockStart(at(2024, 6, 3, 13, 0)), at(2024, 6, 10, 15, 0); !got.Equal(want) {
+		t.Errorf("nextBlockStart = %s, want %s", got, want)
+	}
+}
+
+const monthEndCalendar = "BEGIN:VCALENDAR\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Month-end close\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20240131T170000\r\n" +
+	"DTEND;TZID=Europe/Berlin:20240131T180000\r\n" +
+	"RRULE:FREQ=MONTHLY\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Leap day\r\n" +
+	"DTSTART;VALUE=DATE:20240229\r\n" +
+	"RRULE:FREQ=YEARLY\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Early swim\r\n" +
+	"DTSTART;TZID=Europe/Berlin:19900101T060000\r\n" +
+	"DTEND;TZID=Europe/Berlin:19900101T070000\r\n" +
+	"RRULE:FREQ=DAILY\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestCalendarRecurrenceDates(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	events, err := parseCalendar(strings.NewReader(monthEndCalendar), berlin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calendar := &Calendar{events: events}
+
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, berlin)
+	}
+	tests := []struct {
+		name    string
+		at      time.Time
+		blocked bool
+		until   time.Time
+	}{
+		// Months without a 31st have no occurrence instead of one rolled
+		// over into the next month
+		{"31st of a long month", at(2024, 3, 31, 17, 30), true, at(2024, 3, 31, 18, 0)},
+		{"no rollover from February", at(2024, 3, 2, 17, 30), false, time.Time{}},
+		{"no rollover from April", at(2024, 5, 1, 17, 30), false, time.Time{}},
+		{"31st of the next long month", at(2024, 5, 31, 17, 30), true, at(2024, 5, 31, 18, 0)},
+		{"leap day", at(2028, 2, 29, 12, 0), true, at(2028, 3, 1, 0, 0)},
+		{"no rollover from a common year", at(2025, 3, 1, 12, 0), false, time.Time{}},
+		// Thousands of days after DTSTART
+		{"daily event from decades ago", at(2024, 6, 3, 6, 30), true, at(2024, 6, 3, 7, 0)},
+		{"daily event long after", at(2060, 1, 1, 6, 30), true, at(2060, 1, 1, 7, 0)},
+	}
+	for _, tt := range tests {
+		until, blocked := calendar.blockedUntil(tt.at)
+		if blocked != tt.blocked || !until.Equal(tt.until) {
+			t.Errorf("%s: blockedUntil(%s) = %s, %v, want %s, %v", tt.name, tt.at, until, blocked, tt.until, tt.blocked)
+		}
+	}
+
+	// Leave out the daily swim, which would always come next
+	calendar = &Calendar{events: events[:2]}
+	for _, tt := range []struct {
+		name string
+		at   time.Time
+		want time.Time
+	}{
+		{"after January", at(2024, 1, 31, 18, 0), at(2024, 2, 29, 0, 0)},
+		{"after the leap day", at(2024, 3, 1, 0, 0), at(2024, 3, 31, 17, 0)},
+		{"after March", at(2024, 3, 31, 18, 0), at(2024, 5, 31, 17, 0)},
+	} {
+		if got := calendar.nextBlockStart(tt.at); !got.Equal(tt.want) {
+			t.Errorf("%s: nextBlockStart(%s) = %s, want %s", tt.name, tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestScheduleWithCalendar(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	path := filepath.Join(t.TempDir(), "holidays.ics")
+	if err := os.WriteFile(path, []byte(testCalendar), 0644); err != nil {
+		t.Fatal(err)
+	}
+	calendar, err := LoadCalendar(berlin, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSchedule(t, "mon-fri 09:00-18:00", berlin)
+	s.plan.calendar = calendar
+
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, berlin)
+	}
+	tests := []struct {
+		name     string
+		at       time.Time
+		working  bool
+		nextWork time.Time
+	}{
+		{"all-day holiday", at(5, 20, 10, 0), false, at(5, 21, 9, 0)},
+		{"friday before holiday", at(5, 17, 18, 0), false, at(5, 21, 9, 0)},
+		{"timed event", at(5, 22, 10, 0), false, at(5, 22, 11, 30)},
+		{"after timed event", at(5, 22, 11, 30), true, at(5, 22, 11, 30)},
+		{"cancelled event", at(5, 23, 10, 0), true, at(5, 23, 10, 0)},
+		{"vacation", at(6, 10, 12, 0), false, at(6, 12, 9, 0)},
+		{"recurring holiday", at(5, 1, 12, 0), false, at(5, 2, 9, 0)},
+	}
+	for _, tt := range tests {
+		if got := s.IsWorkingTimeAt(tt.at); got != tt.working {
+			t.Errorf("%s: IsWorkingTimeAt(%s) = %v, want %v", tt.name, tt.at, got, tt.working)
+		}
+		if got := s.GetNextWorkingTimeAt(tt.at); !got.Equal(tt.nextWork) {
+			t.Errorf("%s: GetNextWorkingTimeAt(%s) = %s, want %s", tt.name, tt.at, got, tt.nextWork)
+		}
+	}
+}
+
+func TestNextTransitionAtWithCalendar(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	events, err := parseCalendar(strings.NewReader(testCalendar), berlin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSchedule(t, "mon-fri 09:00-18:00", berlin)
+	s.plan.calendar = &Calendar{events: events}
+
+	// The dentist appointment interrupts working time on 2024-05-22
+	at := time.Date(2024, 5, 22, 9, 0, 0, 0, berlin)
+	if got, want := s.NextTransitionAt(at), time.Date(2024, 5, 22, 10, 0, 0, 0, berlin); !got.Equal(want) {
+		t.Errorf("NextTransitionAt(%s) = %s, want %s", at, got, want)
+	}
+	at = time.Date(2024, 5, 22, 10, 0, 0, 0, berlin)
+	if got, want := s.NextTransitionAt(at), time.Date(2024, 5, 22, 11, 30, 0, 0, berlin); !got.Equal(want) {
+		t.Errorf("NextTransitionAt(%s) = %s, want %s", at, got, want)
+	}
+}
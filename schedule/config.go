@@ -0,0 +1,290 @@
+package schedule
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/lucy/slack-always-active/logger"
+)
+
+// Config describes a schedule. It can be read from environment variables or
+// from a JSON schedule file with the same settings:
+//
+//	{
+//	  "timezone": "Europe/Berlin",
+//	  "work_hours": "mon-thu 09:00-12:00,13:00-18:00; fri 09:00-14:00",
+//	  "holiday_calendars": ["holidays.ics"]
+//	}
+type Config struct {
+	Timezone         string   `json:"timezone,omitempty"`
+	GMTOffset        string   `json:"gmt_offset,omitempty"`
+	WorkDays         string   `json:"work_days,omitempty"`
+	WorkStart        string   `json:"work_start,omitempty"`
+	WorkEnd          string   `json:"work_end,omitempty"`
+	WorkHours        string   `json:"work_hours,omitempty"`
+	WorkCron         string   `json:"work_cron,omitempty"`
+	WorkExceptions   string   `json:"work_exceptions,omitempty"`
+	HolidayCalendars []string `json:"holiday_calendars,omitempty"`
+	Holidays         []string `json:"holidays,omitempty"`
+	JitterStart      string   `json:"jitter_start,omitempty"`
+	JitterEnd        string   `json:"jitter_end,omitempty"`
+	JitterSeed       string   `json:"jitter_seed,omitempty"`
+	CoffeeBreaks     int      `json:"coffee_breaks,omitempty"`
+	CoffeeBreakTime  string   `json:"coffee_break_duration,omitempty"`
+	MaxActivePerDay  string   `json:"max_active_per_day,omitempty"`
+	MaxActivePerWeek string   `json:"max_active_per_week,omitempty"`
+	MaxSession       string   `json:"max_session,omitempty"`
+	SessionBreak     string   `json:"session_break,omitempty"`
+
+	// Compose builds the working windows from other sources. It replaces
+	// the working hours settings and is only available in the schedule file.
+	Compose *SourceConfig `json:"compose,omitempty"`
+}
+
+func configFromEnv() (*Config, error) {
+	var breaks int
+	var err error
+	if breaksStr := os.Getenv("COFFEE_BREAKS"); breaksStr != "" {
+		breaks, err = strconv.Atoi(breaksStr)
+		if err != nil {
+			err = fmt.Errorf("invalid COFFEE_BREAKS: %s", breaksStr)
+		}
+	}
+
+	return &Config{
+		Timezone:         os.Getenv("TIMEZONE"),
+		GMTOffset:        os.Getenv("GMT_OFFSET"),
+		WorkDays:         os.Getenv("WORK_DAYS"),
+		WorkStart:        os.Getenv("WORK_START"),
+		WorkEnd:          os.Getenv("WORK_END"),
+		WorkHours:        os.Getenv("WORK_HOURS"),
+		WorkCron:         os.Getenv("WORK_CRON"),
+		WorkExceptions:   os.Getenv("WORK_EXCEPTIONS"),
+		HolidayCalendars: splitList(os.Getenv("HOLIDAY_CALENDARS")),
+		Holidays:         splitList(os.Getenv("HOLIDAYS")),
+		JitterStart:      os.Getenv("JITTER_START"),
+		JitterEnd:        os.Getenv("JITTER_END"),
+		JitterSeed:       os.Getenv("JITTER_SEED"),
+		CoffeeBreaks:     breaks,
+		CoffeeBreakTime:  os.Getenv("COFFEE_BREAK_DURATION"),
+		MaxActivePerDay:  os.Getenv("MAX_ACTIVE_PER_DAY"),
+		MaxActivePerWeek: os.Getenv("MAX_ACTIVE_PER_WEEK"),
+		MaxSession:       os.Getenv("MAX_SESSION"),
+		SessionBreak:     os.Getenv("SESSION_BREAK"),
+	}, err
+}
+
+// LoadConfig reads a JSON schedule file. Relative calendar paths are
+// resolved against the directory of the file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedule file: %v", err)
+	}
+
+	var config Config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse schedule file %s: %v", path, err)
+	}
+
+	for i, calendar := range config.HolidayCalendars {
+		if !filepath.IsAbs(calendar) {
+			config.HolidayCalendars[i] = filepath.Join(filepath.Dir(path), calendar)
+		}
+	}
+	if config.Compose != nil {
+		config.Compose.resolve(filepath.Dir(path))
+	}
+
+	return &config, nil
+}
+
+// build validates the config and turns it into a plan. All validation
+// errors are reported together.
+func (c *Config) build() (*plan, error) {
+	var errs []error
+
+	// Get time zone, falling back to the GMT offset
+	loc, err := parseLocation(c.Timezone, c.GMTOffset)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error parsing time zone: %v", err))
+		loc = time.UTC
+	}
+
+	// Get working windows, either as cron rules or weekly hours
+	var rules ruleSet
+	calendars := c.HolidayCalendars
+	if c.Compose != nil {
+		source, err := c.Compose.build(loc)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error building composed schedule: %v", err))
+		}
+		if c.WorkHours != "" || c.WorkCron != "" || c.WorkExceptions != "" {
+			errs = append(errs, fmt.Errorf("compose cannot be combined with work_hours, work_cron or work_exceptions"))
+		}
+		rules = &sourceRules{source: source}
+		calendars = append(append([]string(nil), calendars...), c.Compose.calendars()...)
+	} else if c.WorkCron != "" {
+		rules, err = parseCronRules(c.WorkCron, loc)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error parsing cron rules: %v", err))
+		}
+		if c.WorkExceptions != "" {
+			errs = append(errs, fmt.Errorf("date-specific exceptions cannot be combined with cron rules"))
+		}
+	} else {
+		days, err := parseWorkHours(c.WorkHours, c.WorkDays, c.WorkStart, c.WorkEnd)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		exceptions, err := parseExceptions(c.WorkExceptions)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error parsing exceptions: %v", err))
+		}
+		rules = &weeklyRules{days: days, loc: loc, exceptions: exceptions, last: lastException(exceptions, loc)}
+	}
+
+	// Get holiday and vacation calendars
+	var calendar *Calendar
+	if len(c.HolidayCalendars) > 0 {
+		calendar, err = LoadCalendar(loc, c.HolidayCalendars...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error loading holiday calendars: %v", err))
+		}
+	}
+
+	// Get public holidays computed from the built-in rules
+	var holidays *holidays
+	if len(c.Holidays) > 0 {
+		holidays, err = newHolidays(loc, c.Holidays)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error loading holiday rules: %v", err))
+		}
+	}
+
+	// Get randomized jitter and coffee breaks
+	jitter, err := c.parseJitter()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error parsing jitter: %v", err))
+	}
+
+	// Get active-time limits
+	limits, err := c.parseLimits()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return &plan{
+		rules:     rules,
+		loc:       loc,
+		calendar:  calendar,
+		calendars: calendars,
+		holidays:  holidays,
+		limits:    limits,
+		jitter:    jitter,
+	}, nil
+}
+
+// fileStamp identifies a version of a watched file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// stamps returns the current stamps of the schedule file and the calendars
+// it references. Missing files get a zero stamp.
+func (s *Schedule) stamps() map[string]fileStamp {
+	files := append([]string{s.path}, s.current().calendars...)
+	stamps := make(map[string]fileStamp, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			stamps[file] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		} else {
+			stamps[file] = fileStamp{}
+		}
+	}
+	return stamps
+}
+
+// Reload reads the schedule file again and swaps in the new schedule. If
+// the file is invalid the previous schedule stays in effect.
+func (s *Schedule) Reload() error {
+	if s.path == "" {
+		return fmt.Errorf("schedule is not loaded from a file")
+	}
+
+	config, err := LoadConfig(s.path)
+	if err != nil {
+		return err
+	}
+	p, err := config.build()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.plan = p
+	s.notifyLocked()
+	s.mu.Unlock()
+	return nil
+}
+
+// WatchConfig polls the schedule file and the calendars it references
+// every interval and reloads the schedule when any of them changes. It
+// returns when ctx is cancelled, or immediately if the schedule is not
+// loaded from a file.
+func (s *Schedule) WatchConfig(ctx context.Context, interval time.Duration) {
+	if s.path == "" {
+		return
+	}
+
+	ticker := s.getClock().NewTicker(interval)
+	defer ticker.Stop()
+
+	last := s.stamps()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			stamps := s.stamps()
+			if stampsEqual(last, stamps) {
+				continue
+			}
+
+			if err := s.Reload(); err != nil {
+				logger.Error("Invalid schedule in %s, keeping the previous schedule:\n%v", s.path, err)
+				last = stamps
+				continue
+			}
+			logger.Info("Reloaded schedule from %s", s.path)
+			// The new schedule may reference different calendars
+			last = s.stamps()
+		}
+	}
+}
+
+func stampsEqual(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for file, stamp := range a {
+		if other, ok := b[file]; !ok || !other.modTime.Equal(stamp.modTime) || other.size != stamp.size {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package schedule
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloadKeepsPreviousScheduleOnError(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "schedule.json")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"timezone": "UTC", "work_hours": "mon-fri 09:00-18:00"}`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := config.build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Schedule{plan: p, path: path}
+
+	// 2024-06-03 is a Monday
+	evening := time.Date(2024, 6, 3, 19, 0, 0, 0, time.UTC)
+	if s.IsWorkingTimeAt(evening) {
+		t.Fatal("working in the evening before reload")
+	}
+
+	write(`{"timezone": "UTC", "work_hours": "mon-fri 09:00-20:00"}`)
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsWorkingTimeAt(evening) {
+		t.Error("not working in the evening after reload")
+	}
+
+	write(`{"timezone": "Mars/Olympus", "work_hours": "mon-fri 09:00", "holiday_calendar": []}`)
+	if err := s.Reload(); err == nil {
+		t.Fatal("reload of an invalid file succeeded")
+	}
+	write(`{"timezone": "Mars/Olympus", "work_hours": "mon-fri 09:00"}`)
+	err = s.Reload()
+	if err == nil {
+		t.Fatal("reload of an invalid file succeeded")
+	}
+	for _, want := range []string{"time zone", "work hours"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("reload error %q does not mention %s", err, want)
+		}
+	}
+	if !s.IsWorkingTimeAt(evening) {
+		t.Error("invalid reload replaced the previous schedule")
+	}
+}
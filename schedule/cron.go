@@ -0,0 +1,558 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronLookback bounds how far back, in days, a cron rule searches for the
+// firing that opened the current window.
+const cronLookback = 400
+
+// cronLookahead bounds how far ahead, in days, a cron rule searches for its
+// next firing.
+const cronLookahead = 5 * 366
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+}
+
+// cronEpoch is the Monday that week numbers for "%" day-of-week steps are
+// counted from.
+var cronEpoch = time.Date(1970, 1, 5, 0, 0, 0, 0, time.UTC)
+
+// cronExpr is a parsed five-field cron expression: minute, hour, day of
+// month, month and day of week. Besides the standard syntax it supports
+// "L" (last day) and "nW" (nearest weekday) in the day of month, and "d#n"
+// (n-th weekday), "dL" (last weekday) and "d%n" or "d%n+r" (every n-th week)
+// in the day of week.
+type cronExpr struct {
+	source string
+	minute uint64
+	hour   uint64
+	month  uint64
+	dom    uint64
+	dow    uint64
+	domAny bool
+	dowAny bool
+
+	lastDay  bool
+	weekdays []int
+	nth      []nthWeekday
+	steps    []weekStep
+}
+
+// nthWeekday matches the n-th given weekday of the month, or the last one
+// when n is -1.
+type nthWeekday struct {
+	weekday time.Weekday
+	n       int
+}
+
+// weekStep matches the given weekday in every step-th week, counted from
+// cronEpoch, with the given remainder.
+type weekStep struct {
+	weekday time.Weekday
+	step    int
+	rem     int
+}
+
+func parseCron(exprStr string) (*cronExpr, error) {
+	exprStr = strings.TrimSpace(exprStr)
+	source := exprStr
+	if macro, ok := cronMacros[strings.ToLower(exprStr)]; ok {
+		exprStr = macro
+	}
+
+	fields := strings.Fields(exprStr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields", source)
+	}
+
+	expr := &cronExpr{source: source}
+	var err error
+	if expr.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid cron minute %q: %v", fields[0], err)
+	}
+	if expr.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid cron hour %q: %v", fields[1], err)
+	}
+	if err = expr.parseDayOfMonth(fields[2]); err != nil {
+		return nil, fmt.Errorf("invalid cron day of month %q: %v", fields[2], err)
+	}
+	if expr.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("invalid cron month %q: %v", fields[3], err)
+	}
+	if err = expr.parseDayOfWeek(fields[4]); err != nil {
+		return nil, fmt.Errorf("invalid cron day of week %q: %v", fields[4], err)
+	}
+	return expr, nil
+}
+
+func (e *cronExpr) String() string {
+	return e.source
+}
+
+// parseCronField parses a comma-separated list of "*", "n", "a-b" items with
+// optional "/step" into a bit set.
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rangeStr, stepStr, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step: %s", stepStr)
+			}
+		}
+
+		var from, to int
+		switch {
+		case rangeStr == "*" || rangeStr == "?":
+			from, to = min, max
+		default:
+			fromStr, toStr, isRange := strings.Cut(rangeStr, "-")
+			var err error
+			if from, err = parseCronValue(fromStr, min, max, names); err != nil {
+				return 0, err
+			}
+			to = from
+			if isRange {
+				if to, err = parseCronValue(toStr, min, max, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				to = max
+			}
+		}
+		if from > to {
+			return 0, fmt.Errorf("invalid range: %s", rangeStr)
+		}
+
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(valueStr string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(valueStr)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(valueStr)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("invalid value: %s", valueStr)
+	}
+	return v, nil
+}
+
+func (e *cronExpr) parseDayOfMonth(field string) error {
+	e.domAny = field == "*" || field == "?"
+
+	var plain []string
+	for _, item := range strings.Split(field, ",") {
+		switch {
+		case strings.EqualFold(item, "L"):
+			e.lastDay = true
+		case len(item) > 1 && (item[len(item)-1] == 'W' || item[len(item)-1] == 'w'):
+			day, err := parseCronValue(item[:len(item)-1], 1, 31, nil)
+			if err != nil {
+				return err
+			}
+			e.weekdays = append(e.weekdays, day)
+		default:
+			plain = append(plain, item)
+		}
+	}
+
+	if len(plain) > 0 {
+		var err error
+		if e.dom, err = parseCronField(strings.Join(plain, ","), 1, 31, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *cronExpr) parseDayOfWeek(field string) error {
+	e.dowAny = field == "*" || field == "?"
+
+	var plain []string
+	for _, item := range strings.Split(field, ",") {
+		switch {
+		case strings.Contains(item, "#"):
+			dayStr, nStr, _ := strings.Cut(item, "#")
+			day, err := parseWeekday(dayStr)
+			if err != nil {
+				return err
+			}
+			n, err := strconv.Atoi(nStr)
+			if err != nil || n < 1 || n > 5 {
+				return fmt.Errorf("invalid week number: %s", nStr)
+			}
+			e.nth = append(e.nth, nthWeekday{weekday: day, n: n})
+		case strings.Contains(item, "%"):
+			dayStr, stepStr, _ := strings.Cut(item, "%")
+			day, err := parseWeekday(dayStr)
+			if err != nil {
+				return err
+			}
+			stepStr, remStr, hasRem := strings.Cut(stepStr, "+")
+			step, err := strconv.Atoi(stepStr)
+			if err != nil || step < 1 {
+				return fmt.Errorf("invalid week step: %s", stepStr)
+			}
+			rem := 0
+			if hasRem {
+				rem, err = strconv.Atoi(remStr)
+				if err != nil || rem < 0 || rem >= step {
+					return fmt.Errorf("invalid week offset: %s", remStr)
+				}
+			}
+			e.steps = append(e.steps, weekStep{weekday: day, step: step, rem: rem})
+		case len(item) > 1 && (item[len(item)-1] == 'L' || item[len(item)-1] == 'l'):
+			day, err := parseWeekday(item[:len(item)-1])
+			if err != nil {
+				return err
+			}
+			e.nth = append(e.nth, nthWeekday{weekday: day, n: -1})
+		default:
+			plain = append(plain, item)
+		}
+	}
+
+	if len(plain) > 0 {
+		bits, err := parseCronField(strings.Join(plain, ","), 0, 7, cronDayNames())
+		if err != nil {
+			return err
+		}
+		// Both 0 and 7 mean Sunday
+		if bits&(1<<7) != 0 {
+			bits |= 1
+		}
+		e.dow = bits
+	}
+	return nil
+}
+
+func cronDayNames() map[string]int {
+	names := make(map[string]int, len(dayNames))
+	for name, day := range dayNames {
+		names[name] = int(day)
+	}
+	return names
+}
+
+func parseWeekday(dayStr string) (time.Weekday, error) {
+	day, err := parseCronValue(dayStr, 0, 7, cronDayNames())
+	if err != nil {
+		return 0, err
+	}
+	return time.Weekday(day % 7), nil
+}
+
+// matchesDay reports whether the expression fires on the given date. As in
+// standard cron, when both the day of month and the day of week are
+// restricted, either one matching is enough.
+func (e *cronExpr) matchesDay(year int, month time.Month, day int) bool {
+	if e.month&(1<<uint(month)) == 0 {
+		return false
+	}
+
+	domMatch := e.matchesDayOfMonth(year, month, day)
+	dowMatch := e.matchesDayOfWeek(year, month, day)
+	if e.domAny || e.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func (e *cronExpr) matchesDayOfMonth(year int, month time.Month, day int) bool {
+	if e.domAny || e.dom&(1<<uint(day)) != 0 {
+		return true
+	}
+
+	lastDay := daysIn(year, month)
+	if e.lastDay && day == lastDay {
+		return true
+	}
+	for _, target := range e.weekdays {
+		if nearestWeekday(year, month, target, lastDay) == day {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *cronExpr) matchesDayOfWeek(year int, month time.Month, day int) bool {
+	date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	weekday := date.Weekday()
+	if e.dowAny || e.dow&(1<<uint(weekday)) != 0 {
+		return true
+	}
+
+	for _, nth := range e.nth {
+		if nth.weekday != weekday {
+			continue
+		}
+		if nth.n == -1 && day+7 > daysIn(year, month) {
+			return true
+		}
+		if nth.n == (day-1)/7+1 {
+			return true
+		}
+	}
+
+	days := int(date.Sub(cronEpoch).Hours()) / 24
+	week := (days - ((days%7)+7)%7) / 7
+	for _, step := range e.steps {
+		if step.weekday == weekday && ((week%step.step)+step.step)%step.step == step.rem {
+			return true
+		}
+	}
+	return false
+}
+
+// daysIn returns the number of days in the given month.
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// nearestWeekday returns the weekday closest to the target day that lies in
+// the same month.
+func nearestWeekday(year int, month time.Month, target, lastDay int) int {
+	if target > lastDay {
+		target = lastDay
+	}
+	switch time.Date(year, month, target, 0, 0, 0, 0, time.UTC).Weekday() {
+	case time.Saturday:
+		if target == 1 {
+			return target + 2
+		}
+		return target - 1
+	case time.Sunday:
+		if target == lastDay {
+			return target - 2
+		}
+		return target + 1
+	}
+	return target
+}
+
+// next returns the first firing strictly after t, or the zero time if there
+// is none within cronLookahead days.
+func (e *cronExpr) next(t time.Time, loc *time.Location) time.Time {
+	now := t.In(loc)
+	for i := 0; i <= cronLookahead; i++ {
+		date := time.Date(now.Year(), now.Month(), now.Day()+i, 0, 0, 0, 0, loc)
+		if !e.matchesDay(date.Year(), date.Month(), date.Day()) {
+			continue
+		}
+		for hour := 0; hour < 24; hour++ {
+			if e.hour&(1<<uint(hour)) == 0 {
+				continue
+			}
+			for minute := 0; minute < 60; minute++ {
+				if e.minute&(1<<uint(minute)) == 0 {
+					continue
+				}
+				fire := time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, loc)
+				if fire.After(now) {
+					return fire
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// prev returns the last firing at or before t, or the zero time if there is
+// none within cronLookback days.
+func (e *cronExpr) prev(t time.Time, loc *time.Location) time.Time {
+	now := t.In(loc)
+	for i := 0; i <= cronLookback; i++ {
+		date := time.Date(now.Year(), now.Month(), now.Day()-i, 0, 0, 0, 0, loc)
+		if !e.matchesDay(date.Year(), date.Month(), date.Day()) {
+			continue
+		}
+		for hour := 23; hour >= 0; hour-- {
+			if e.hour&(1<<uint(hour)) == 0 {
+				continue
+			}
+			for minute := 59; minute >= 0; minute-- {
+				if e.minute&(1<<uint(minute)) == 0 {
+					continue
+				}
+				fire := time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, loc)
+				if !fire.After(now) {
+					return fire
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// cronWindow is an active window opened by one cron expression and closed
+// either by another expression or after a fixed duration.
+type cronWindow struct {
+	open     *cronExpr
+	close    *cronExpr
+	duration time.Duration
+}
+
+func (w cronWindow) String() string {
+	if w.close != nil {
+		return fmt.Sprintf("%s | %s", w.open, w.close)
+	}
+	return fmt.Sprintf("%s | %s", w.open, w.duration)
+}
+
+// parseCronWindow parses "open | close" where close is a cron expression or
+// a duration such as "8h30m".
+func parseCronWindow(windowStr string) (cronWindow, error) {
+	openStr, closeStr, ok := strings.Cut(windowStr, "|")
+	if !ok {
+		return cronWindow{}, fmt.Errorf("invalid cron window %q: expected \"open | close\"", windowStr)
+	}
+
+	open, err := parseCron(openStr)
+	if err != nil {
+		return cronWindow{}, err
+	}
+	w := cronWindow{open: open}
+
+	closeStr = strings.TrimSpace(closeStr)
+	if duration, err := time.ParseDuration(closeStr); err == nil {
+		if duration <= 0 {
+			return cronWindow{}, fmt.Errorf("invalid cron window %q: duration must be positive", windowStr)
+		}
+		w.duration = duration
+		return w, nil
+	}
+
+	if w.close, err = parseCron(closeStr); err != nil {
+		return cronWindow{}, err
+	}
+	return w, nil
+}
+
+// activeAt reports whether the window is open at t. With a close
+// expression the window is open when the latest opening firing is later
+// than the latest closing one.
+func (w cronWindow) activeAt(t time.Time, loc *time.Location) bool {
+	opened := w.open.prev(t, loc)
+	if opened.IsZero() {
+		return false
+	}
+	if w.close == nil {
+		return t.Before(opened.Add(w.duration))
+	}
+	closed := w.close.prev(t, loc)
+	return closed.IsZero() || opened.After(closed)
+}
+
+// nextStart returns the first time after t at which the window opens.
+func (w cronWindow) nextStart(t time.Time, loc *time.Location) time.Time {
+	next := w.open.next(t, loc)
+	for i := 0; i < maxSearch && !next.IsZero(); i++ {
+		if w.activeAt(next, loc) {
+			return next
+		}
+		next = w.open.next(next, loc)
+	}
+	return time.Time{}
+}
+
+// end returns the time the window that is open at t closes, or the zero
+// time if it never does.
+func (w cronWindow) end(t time.Time, loc *time.Location) time.Time {
+	if w.close == nil {
+		return w.open.prev(t, loc).Add(w.duration)
+	}
+	return w.close.next(t, loc)
+}
+
+// cronRules is a schedule made of cron windows; it is working time whenever
+// any of the windows is open.
+type cronRules struct {
+	windows []cronWindow
+	loc     *time.Location
+}
+
+// parseCronRules parses a ";"-separated list of cron windows.
+func parseCronRules(rulesStr string, loc *time.Location) (*cronRules, error) {
+	rules := &cronRules{loc: loc}
+	for _, windowStr := range strings.Split(rulesStr, ";") {
+		if strings.TrimSpace(windowStr) == "" {
+			continue
+		}
+		w, err := parseCronWindow(windowStr)
+		if err != nil {
+			return nil, err
+		}
+		rules.windows = append(rules.windows, w)
+	}
+	if len(rules.windows) == 0 {
+		return nil, fmt.Errorf("no cron windows defined")
+	}
+	return rules, nil
+}
+
+func (r *cronRules) inWindow(t time.Time) bool {
+	for _, w := range r.windows {
+		if w.activeAt(t, r.loc) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *cronRules) nextWindowStart(t time.Time) time.Time {
+	var earliest time.Time
+	for _, w := range r.windows {
+		next := w.nextStart(t, r.loc)
+		if !next.IsZero() && (earliest.IsZero() || next.Before(earliest)) {
+			earliest = next
+		}
+	}
+	return earliest
+}
+
+// windowEnd returns the time all windows open at t have closed. A window
+// opening while another is still open extends the working period.
+func (r *cronRules) windowEnd(t time.Time) time.Time {
+	end := t
+	for i := 0; i < maxSearch && r.inWindow(end); i++ {
+		latest := end
+		for _, w := range r.windows {
+			if !w.activeAt(end, r.loc) {
+				continue
+			}
+			windowEnd := w.end(end, r.loc)
+			if windowEnd.IsZero() {
+				return windowEnd
+			}
+			if windowEnd.After(latest) {
+				latest = windowEnd
+			}
+		}
+		end = latest
+	}
+	return end.In(r.loc)
+}
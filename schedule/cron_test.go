@@ -0,0 +1,84 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func newCronSchedule(t *testing.T, rules string) *Schedule {
+	t.Helper()
+	r, err := parseCronRules(rules, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Schedule{plan: &plan{rules: r, loc: time.UTC}}
+}
+
+func TestCronRules(t *testing.T) {
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name     string
+		rules    string
+		at       time.Time
+		working  bool
+		nextWork time.Time
+	}{
+		// 2024-06-01 is a Saturday, so the first weekday of June is the 3rd
+		{"first weekday before", "0 9 1W * * | 8h", at(6, 1, 10, 0), false, at(6, 3, 9, 0)},
+		{"first weekday", "0 9 1W * * | 8h", at(6, 3, 16, 59), true, at(6, 3, 16, 59)},
+		{"first weekday end", "0 9 1W * * | 8h", at(6, 3, 17, 0), false, at(7, 1, 9, 0)},
+		{"pair open", "0 9 * * 1-5 | 0 17 * * 1-5", at(6, 7, 9, 0), true, at(6, 7, 9, 0)},
+		{"pair closed", "0 9 * * 1-5 | 0 17 * * 1-5", at(6, 7, 17, 0), false, at(6, 10, 9, 0)},
+		{"overnight pair", "0 22 * * FRI | 0 6 * * SAT", at(6, 8, 5, 59), true, at(6, 8, 5, 59)},
+		{"overnight pair closed", "0 22 * * FRI | 0 6 * * SAT", at(6, 8, 6, 0), false, at(6, 14, 22, 0)},
+		{"first friday", "0 9 * * FRI#1 | 3h", at(6, 5, 10, 0), false, at(6, 7, 9, 0)},
+		{"last friday", "0 9 * * 5L | 3h", at(6, 28, 10, 0), true, at(6, 28, 10, 0)},
+		{"last day of month", "30 8 L * * | 1h", at(6, 29, 9, 0), false, at(6, 30, 8, 30)},
+		{"dom or dow", "0 9 15 * MON | 1h", at(6, 15, 9, 30), true, at(6, 15, 9, 30)},
+		{"union", "0 9 * * MON | 1h; 0 13 * * MON | 1h", at(6, 3, 10, 0), false, at(6, 3, 13, 0)},
+	}
+	for _, tt := range tests {
+		s := newCronSchedule(t, tt.rules)
+		if got := s.IsWorkingTimeAt(tt.at); got != tt.working {
+			t.Errorf("%s: IsWorkingTimeAt(%s) = %v, want %v", tt.name, tt.at, got, tt.working)
+		}
+		if got := s.GetNextWorkingTimeAt(tt.at); !got.Equal(tt.nextWork) {
+			t.Errorf("%s: GetNextWorkingTimeAt(%s) = %s, want %s", tt.name, tt.at, got, tt.nextWork)
+		}
+	}
+}
+
+func TestCronEveryOtherWeek(t *testing.T) {
+	even := newCronSchedule(t, "0 9 * * MON%2 | 0 17 * * MON%2")
+	odd := newCronSchedule(t, "0 9 * * MON%2+1 | 0 17 * * MON%2+1")
+
+	first := even.IsWorkingTimeAt(time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC))
+	for week := 0; week < 6; week++ {
+		monday := time.Date(2024, 6, 3+7*week, 10, 0, 0, 0, time.UTC)
+		if want := first == (week%2 == 0); even.IsWorkingTimeAt(monday) != want {
+			t.Errorf("%s: IsWorkingTimeAt = %v, want %v", monday, !want, want)
+		}
+		if even.IsWorkingTimeAt(monday) == odd.IsWorkingTimeAt(monday) {
+			t.Errorf("%s: even and odd weeks agree", monday)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, bad := range []string{
+		"0 9 * * MON",
+		"0 9 * * | 8h",
+		"60 9 * * * | 8h",
+		"0 9 * * MON | -1h",
+		"0 9 * * MON#6 | 8h",
+		"0 9 * * MON%2+2 | 8h",
+		"0 9 32W * * | 8h",
+		"0 9 * * MON | 0 17 * *",
+	} {
+		if _, err := parseCronRules(bad, time.UTC); err == nil {
+			t.Errorf("parseCronRules(%q) succeeded, want error", bad)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// date is a calendar day in the schedule location.
+type date struct {
+	year  int
+	month time.Month
+	day   int
+}
+
+// dateOn normalizes a day that may be out of range, such as the 0th or 32nd.
+func dateOn(year int, month time.Month, day int) date {
+	t := time.Date(year, month, day, 12, 0, 0, 0, time.UTC)
+	return date{t.Year(), t.Month(), t.Day()}
+}
+
+func (d date) String() string {
+	return fmt.Sprintf("%04d-%02d-%02d", d.year, d.month, d.day)
+}
+
+// parseExceptions parses date-specific windows such as
+// "2024-12-24 09:00-13:00; 2024-12-28 20:00-02:00; 2024-12-31 off". The
+// windows of a date replace the weekly windows that start on that date; an
+// empty list marks the date off.
+func parseExceptions(exceptionsStr string) (map[date][]window, error) {
+	exceptions := make(map[date][]window)
+
+	for _, entry := range strings.Split(exceptionsStr, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		dateStr, windowsStr, ok := strings.Cut(entry, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid exception %q: expected a date followed by windows or \"off\"", entry)
+		}
+
+		day, err := time.Parse("2006-01-02", dateStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exception %q: date must be YYYY-MM-DD", entry)
+		}
+		d := date{day.Year(), day.Month(), day.Day()}
+		if _, ok := exceptions[d]; ok {
+			return nil, fmt.Errorf("duplicate exception for %s", d)
+		}
+
+		windows := []window{}
+		if windowsStr = strings.TrimSpace(windowsStr); !strings.EqualFold(windowsStr, "off") {
+			for _, windowStr := range strings.Split(windowsStr, ",") {
+				w, err := parseWindow(windowStr)
+				if err != nil {
+					return nil, fmt.Errorf("invalid exception %q: %v", entry, err)
+				}
+				windows = append(windows, w)
+			}
+			if err := normalizeWindows(windows); err != nil {
+				return nil, fmt.Errorf("%s: %v", d, err)
+			}
+		}
+		exceptions[d] = windows
+	}
+
+	return exceptions, nil
+}
+
+// lastException returns the latest exception date, at noon in loc, or the
+// zero time if there are no exceptions.
+func lastException(exceptions map[date][]window, loc *time.Location) time.Time {
+	var last time.Time
+	for d := range exceptions {
+		if t := time.Date(d.year, d.month, d.day, 12, 0, 0, 0, loc); t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
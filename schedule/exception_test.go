@@ -0,0 +1,104 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExceptions(t *testing.T) {
+	exceptions, err := parseExceptions("2024-12-24 09:00-13:00; 2024-12-28 20:00-02:00; 2024-12-31 OFF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exceptions) != 3 {
+		t.Fatalf("got %d exceptions, want 3", len(exceptions))
+	}
+	if w := exceptions[date{2024, 12, 31}]; w == nil || len(w) != 0 {
+		t.Errorf("2024-12-31 should be off, got %v", w)
+	}
+
+	for _, invalid := range []string{
+		"2024-12-24",
+		"24.12.2024 09:00-13:00",
+		"2024-12-24 09:00-13:00; 2024-12-24 off",
+		"2024-12-24 09:00-13:00,12:00-14:00",
+		"2024-12-24 later",
+	} {
+		if _, err := parseExceptions(invalid); err == nil {
+			t.Errorf("parseExceptions(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	loc := mustLoad(t, "Europe/Berlin")
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, loc)
+	}
+	s := mustSchedule(t, &Config{
+		Timezone:       "Europe/Berlin",
+		WorkHours:      "mon-thu 09:00-18:00; fri 22:00-06:00",
+		WorkExceptions: "2024-12-24 09:00-13:00; 2024-12-28 20:00-02:00; 2024-12-27 off; 2024-12-30 off; 2024-12-31 off",
+	})
+
+	tests := []struct {
+		t    time.Time
+		want bool
+	}{
+		{at(12, 24, 12, 59), true},
+		{at(12, 24, 13, 0), false},
+		{at(12, 27, 23, 0), false}, // Friday night shift is off
+		{at(12, 28, 1, 0), false},
+		{at(12, 28, 21, 0), true}, // Saturday release night
+		{at(12, 29, 1, 59), true},
+		{at(12, 29, 2, 0), false},
+		{at(12, 30, 10, 0), false},
+	}
+	for _, tt := range tests {
+		if got := s.IsWorkingTimeAt(tt.t); got != tt.want {
+			t.Errorf("IsWorkingTimeAt(%v) = %v, want %v", tt.t, got, tt.want)
+		}
+	}
+
+	if got := s.GetNextWorkingTimeAt(at(12, 24, 14, 0)); !got.Equal(at(12, 25, 9, 0)) {
+		t.Errorf("GetNextWorkingTimeAt after the half-day = %v, want 2024-12-25 09:00", got)
+	}
+	if got := s.GetNextWorkingTimeAt(at(12, 26, 19, 0)); !got.Equal(at(12, 28, 20, 0)) {
+		t.Errorf("GetNextWorkingTimeAt = %v, want the Saturday release night", got)
+	}
+	if got := s.NextTransitionAt(at(12, 28, 21, 0)); !got.Equal(at(12, 29, 2, 0)) {
+		t.Errorf("NextTransitionAt = %v, want 2024-12-29 02:00", got)
+	}
+
+	explanation := s.Explain(at(12, 31, 10, 0)).String()
+	if !strings.Contains(explanation, "exception for 2024-12-31 marks the day off") {
+		t.Errorf("Explain does not mention the exception:\n%s", explanation)
+	}
+}
+
+func TestExceptionsBeyondAWeek(t *testing.T) {
+	// Only exceptions open working time: the next one is months away
+	s := mustSchedule(t, &Config{
+		Timezone:       "UTC",
+		WorkHours:      "mon 09:00-10:00",
+		WorkExceptions: "2024-01-01 off; 2024-01-08 off; 2024-01-15 off; 2024-01-22 off; 2024-06-01 10:00-12:00",
+	})
+
+	got := s.GetNextWorkingTimeAt(time.Date(2023, 12, 31, 12, 0, 0, 0, time.UTC))
+	if want := time.Date(2024, 1, 29, 9, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("GetNextWorkingTimeAt = %v, want %v", got, want)
+	}
+
+	// No weekly windows at all
+	exceptions, err := parseExceptions("2024-06-01 10:00-12:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := &weeklyRules{loc: time.UTC, exceptions: exceptions, last: lastException(exceptions, time.UTC)}
+	s = &Schedule{plan: &plan{rules: rules, loc: time.UTC}}
+	got = s.GetNextWorkingTimeAt(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	if want := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("GetNextWorkingTimeAt = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,205 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Explanation describes why a time is or is not working time.
+type Explanation struct {
+	Time    time.Time
+	Working bool
+	// Reasons lists the rules that decided the result, most general first.
+	Reasons []string
+	// Next is the next transition, or the zero time if there is none.
+	Next time.Time
+}
+
+func (e Explanation) String() string {
+	var b strings.Builder
+	state := "inactive"
+	if e.Working {
+		state = "active"
+	}
+	fmt.Fprintf(&b, "%s: %s\n", formatTime(e.Time), state)
+	for _, reason := range e.Reasons {
+		fmt.Fprintf(&b, "  - %s\n", reason)
+	}
+	if e.Next.IsZero() {
+		b.WriteString("  No further changes in the schedule\n")
+	} else {
+		fmt.Fprintf(&b, "  Next change: %s\n", formatTime(e.Next))
+	}
+	return b.String()
+}
+
+func formatTime(t time.Time) string {
+	return t.Format("Mon 2006-01-02 15:04:05 MST")
+}
+
+// Explain reports whether t is working time and which rules decided it.
+func (s *Schedule) Explain(t time.Time) Explanation {
+	return s.effective().explain(t)
+}
+
+func (e effective) explain(t time.Time) Explanation {
+	x := e.plan.explain(t)
+	if e.override.activeAt(t) {
+		x.Reasons = append(x.Reasons, e.override.describe(e.plan.loc))
+	}
+	x.Working = e.workingAt(t)
+	x.Next = e.nextTransitionAt(t)
+	return x
+}
+
+func (p *plan) explain(t time.Time) Explanation {
+	e := Explanation{
+		Time:    t.In(p.loc),
+		Working: p.isWorkingTimeAt(t),
+		Next:    p.nextTransitionAt(t),
+	}
+
+	e.Reasons = append(e.Reasons, p.rules.explain(t))
+
+	if name, day, ok := p.holidays.holidayAt(t); ok {
+		e.Reasons = append(e.Reasons, fmt.Sprintf("public holiday %s on %s", name, day.start.In(p.loc).Format("Mon 2006-01-02")))
+	}
+
+	if summary, i, ok := p.calendar.eventAt(t); ok {
+		e.Reasons = append(e.Reasons, fmt.Sprintf("holiday or vacation %q from %s to %s", summary, formatTime(i.start.In(p.loc)), formatTime(i.end.In(p.loc))))
+	}
+
+	if p.jitter != nil {
+		e.Reasons = append(e.Reasons, p.jitter.explain(p, t)...)
+	}
+	return e
+}
+
+// explain describes the jitter or coffee break that affects t, if any.
+func (j *jitter) explain(p *plan, t time.Time) []string {
+	var reasons []string
+
+	next := p.rawNextTransitionAt(t.Add(-j.reach()))
+	for i := 0; i < maxSearch && !next.IsZero() && !next.After(t.Add(j.reach())); i++ {
+		starts := p.rawWorkingAt(next)
+		kind := "end"
+		if starts {
+			kind = "start"
+		}
+		shifted := next.Add(j.shift(p, next, starts))
+		switch {
+		case !next.After(t) && shifted.After(t):
+			reasons = append(reasons, fmt.Sprintf("jitter delays the %s at %s to %s", kind, formatTime(next.In(p.loc)), formatTime(shifted.In(p.loc))))
+		case next.After(t) && !shifted.After(t):
+			reasons = append(reasons, fmt.Sprintf("jitter brings the %s at %s forward to %s", kind, formatTime(next.In(p.loc)), formatTime(shifted.In(p.loc))))
+		}
+		next = p.rawNextTransitionAt(next)
+	}
+
+	local := t.In(p.loc)
+	for _, b := range j.breaksOn(p, local.Year(), local.Month(), local.Day()) {
+		if b.contains(t) {
+			reasons = append(reasons, fmt.Sprintf("coffee break from %s to %s", formatTime(b.start.In(p.loc)), formatTime(b.end.In(p.loc))))
+		}
+	}
+	return reasons
+}
+
+// explain describes the weekly window, or the working days, that decide
+// whether t falls within a window, and the date-specific exception that
+// applied, if any.
+func (r *weeklyRules) explain(t time.Time) string {
+	now := t.In(r.loc)
+
+	for day := now.Day() - 1; day <= now.Day(); day++ {
+		start := time.Date(now.Year(), now.Month(), day, 12, 0, 0, 0, r.loc)
+		windows, exception := r.windowsOn(now.Year(), now.Month(), day)
+		for _, w := range windows {
+			if !w.interval(now.Year(), now.Month(), day, r.loc).contains(now) {
+				continue
+			}
+			switch {
+			case exception && day != now.Day():
+				return fmt.Sprintf("within the overnight window %s that started on %s, from the exception for %s", w, start.Weekday(), dateOn(now.Year(), now.Month(), day))
+			case exception:
+				return fmt.Sprintf("within the window %s from the exception for %s", w, dateOn(now.Year(), now.Month(), day))
+			case day != now.Day():
+				return fmt.Sprintf("within the overnight window %s that started on %s", w, start.Weekday())
+			default:
+				return fmt.Sprintf("within the %s window %s", start.Weekday(), w)
+			}
+		}
+	}
+
+	windows, exception := r.windowsOn(now.Year(), now.Month(), now.Day())
+	today := dateOn(now.Year(), now.Month(), now.Day())
+	switch {
+	case exception && len(windows) == 0:
+		return fmt.Sprintf("the exception for %s marks the day off", today)
+	case len(windows) == 0:
+		return fmt.Sprintf("%s is not a working day (working days: %s)", now.Weekday(), r.workingDays())
+	}
+
+	bounds := make([]string, len(windows))
+	for i, w := range windows {
+		bounds[i] = w.String()
+	}
+	if exception {
+		return fmt.Sprintf("outside the windows %s from the exception for %s", strings.Join(bounds, ", "), today)
+	}
+	return fmt.Sprintf("outside the %s windows %s", now.Weekday(), strings.Join(bounds, ", "))
+}
+
+func (r *weeklyRules) workingDays() string {
+	var days []string
+	for day := time.Monday; ; day = (day + 1) % 7 {
+		if len(r.days[day]) > 0 {
+			days = append(days, day.String())
+		}
+		if day == time.Sunday {
+			break
+		}
+	}
+	if len(days) == 0 {
+		return "none"
+	}
+	return strings.Join(days, ", ")
+}
+
+// explain describes the cron window that is open at t, if any.
+func (r *cronRules) explain(t time.Time) string {
+	for _, w := range r.windows {
+		if w.activeAt(t, r.loc) {
+			return fmt.Sprintf("within the cron window %q opened at %s", w, formatTime(w.open.prev(t, r.loc)))
+		}
+	}
+	return "no cron window is open"
+}
+
+// Period is a span of working time. End is the zero time if the period
+// never ends.
+type Period struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Preview returns up to n working periods, starting with the one in
+// progress at from, if any.
+func (s *Schedule) Preview(from time.Time, n int) []Period {
+	e := s.effective()
+	var periods []Period
+	for t := from; len(periods) < n; {
+		start := e.nextWorkingAt(t)
+		if start.IsZero() {
+			break
+		}
+		end := e.nextTransitionAt(start)
+		periods = append(periods, Period{Start: start, End: end})
+		if end.IsZero() {
+			break
+		}
+		t = end
+	}
+	return periods
+}
@@ -0,0 +1,60 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreview(t *testing.T) {
+	loc := mustLoad(t, "Europe/Berlin")
+	s := newTestSchedule(t, "mon-thu 09:00-12:00,13:00-18:00; fri 22:00-06:00", loc)
+
+	// Starts inside the Monday morning window
+	periods := s.Preview(time.Date(2024, 6, 3, 10, 0, 0, 0, loc), 4)
+	want := []Period{
+		{time.Date(2024, 6, 3, 10, 0, 0, 0, loc), time.Date(2024, 6, 3, 12, 0, 0, 0, loc)},
+		{time.Date(2024, 6, 3, 13, 0, 0, 0, loc), time.Date(2024, 6, 3, 18, 0, 0, 0, loc)},
+		{time.Date(2024, 6, 4, 9, 0, 0, 0, loc), time.Date(2024, 6, 4, 12, 0, 0, 0, loc)},
+		{time.Date(2024, 6, 4, 13, 0, 0, 0, loc), time.Date(2024, 6, 4, 18, 0, 0, 0, loc)},
+	}
+	if len(periods) != len(want) {
+		t.Fatalf("got %d periods, want %d", len(periods), len(want))
+	}
+	for i := range want {
+		if !periods[i].Start.Equal(want[i].Start) || !periods[i].End.Equal(want[i].End) {
+			t.Errorf("period %d = %v - %v, want %v - %v", i, periods[i].Start, periods[i].End, want[i].Start, want[i].End)
+		}
+	}
+
+	// The overnight window ends on Saturday
+	periods = s.Preview(time.Date(2024, 6, 7, 12, 0, 0, 0, loc), 1)
+	if len(periods) != 1 || !periods[0].End.Equal(time.Date(2024, 6, 8, 6, 0, 0, 0, loc)) {
+		t.Errorf("overnight period = %v", periods)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	loc := mustLoad(t, "Europe/Berlin")
+	s := newTestSchedule(t, "mon-thu 09:00-12:00,13:00-18:00; fri 22:00-06:00", loc)
+
+	tests := []struct {
+		at      time.Time
+		working bool
+		reason  string
+	}{
+		{time.Date(2024, 6, 3, 17, 59, 0, 0, loc), true, "13:00-18:00"},
+		{time.Date(2024, 6, 3, 12, 30, 0, 0, loc), false, "outside the Monday windows"},
+		{time.Date(2024, 6, 8, 1, 0, 0, 0, loc), true, "started on Friday"},
+		{time.Date(2024, 6, 9, 12, 0, 0, 0, loc), false, "Sunday is not a working day"},
+	}
+	for _, tt := range tests {
+		e := s.Explain(tt.at)
+		if e.Working != tt.working {
+			t.Errorf("Explain(%v).Working = %v, want %v", tt.at, e.Working, tt.working)
+		}
+		if !strings.Contains(e.String(), tt.reason) {
+			t.Errorf("Explain(%v) = %q, want it to mention %q", tt.at, e.String(), tt.reason)
+		}
+	}
+}
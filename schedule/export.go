@@ -0,0 +1,89 @@
+package schedule
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// icsTime is the iCalendar format of a UTC time.
+const icsTime = "20060102T150405Z"
+
+// Periods returns the working periods between from and until, clipped to
+// that range, with holidays, exceptions and overrides applied.
+func (s *Schedule) Periods(from, until time.Time) []Period {
+	e := s.effective()
+	var periods []Period
+	for t := from; t.Before(until) && len(periods) < maxSearch; {
+		start := e.nextWorkingAt(t)
+		if start.IsZero() || !start.Before(until) {
+			break
+		}
+		end := e.nextTransitionAt(start)
+		if end.IsZero() || end.After(until) {
+			end = until.In(start.Location())
+		}
+		periods = append(periods, Period{Start: start, End: end})
+		t = end
+	}
+	return periods
+}
+
+// WriteCalendar writes the working periods between from and until as an
+// iCalendar feed with one event per period. Times are written in UTC, so
+// calendar clients show them in their own time zone.
+func (s *Schedule) WriteCalendar(w io.Writer, from, until time.Time, summary string) error {
+	b := bufio.NewWriter(w)
+	line := func(format string, args ...interface{}) {
+		writeFolded(b, fmt.Sprintf(format, args...))
+	}
+
+	stamp := s.now().UTC().Format(icsTime)
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//slack-always-active//schedule//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:%s", escapeText(summary))
+	for _, p := range s.Periods(from, until) {
+		start := p.Start.UTC().Format(icsTime)
+		line("BEGIN:VEVENT")
+		// The start identifies a period, so clients update rather than
+		// duplicate events when the feed is fetched again
+		line("UID:%s@slack-always-active", start)
+		line("DTSTAMP:%s", stamp)
+		line("DTSTART:%s", start)
+		line("DTEND:%s", p.End.UTC().Format(icsTime))
+		line("SUMMARY:%s", escapeText(summary))
+		line("TRANSP:TRANSPARENT")
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return b.Flush()
+}
+
+// escapeText escapes an iCalendar TEXT value.
+func escapeText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(text)
+}
+
+// writeFolded writes a content line, folded after 75 octets as RFC 5545
+// requires, without splitting UTF-8 sequences.
+func writeFolded(w *bufio.Writer, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space
+		limit = 74
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
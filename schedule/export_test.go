@@ -0,0 +1,79 @@
+package schedule
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lucy/slack-always-active/clock"
+)
+
+func TestWriteCalendar(t *testing.T) {
+	loc := mustLoad(t, "Europe/Berlin")
+	s := mustSchedule(t, &Config{
+		Timezone:       "Europe/Berlin",
+		WorkHours:      "mon-fri 09:00-17:00",
+		WorkExceptions: "2024-12-24 09:00-12:00",
+		Holidays:       []string{"DE"},
+	})
+	s.SetClock(clock.NewFake(time.Date(2024, 12, 23, 8, 0, 0, 0, loc)))
+	if err := s.ForceActiveUntil(time.Date(2024, 12, 23, 20, 0, 0, 0, loc)); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	from := time.Date(2024, 12, 23, 0, 0, 0, 0, loc)
+	if err := s.WriteCalendar(&b, from, from.AddDate(0, 0, 7), "Slack active"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read the feed back with the calendar parser
+	events, err := parseCalendar(strings.NewReader(b.String()), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interval{
+		// The override starts at 08:00 and runs into the evening
+		{time.Date(2024, 12, 23, 8, 0, 0, 0, loc), time.Date(2024, 12, 23, 20, 0, 0, 0, loc)},
+		// Half-day exception on Christmas Eve, then the Christmas holidays
+		{time.Date(2024, 12, 24, 9, 0, 0, 0, loc), time.Date(2024, 12, 24, 12, 0, 0, 0, loc)},
+		{time.Date(2024, 12, 27, 9, 0, 0, 0, loc), time.Date(2024, 12, 27, 17, 0, 0, 0, loc)},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d:\n%s", len(events), len(want), b.String())
+	}
+	for i, e := range events {
+		if !e.start.Equal(want[i].start) || !e.end.Equal(want[i].end) || e.summary != "Slack active" {
+			t.Errorf("event %d = %q %v - %v, want %v - %v", i, e.summary, e.start, e.end, want[i].start, want[i].end)
+		}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(b.String()))
+	for scanner.Scan() {
+		if len(scanner.Text()) > 76 {
+			t.Errorf("line longer than 75 octets: %q", scanner.Text())
+		}
+	}
+}
+
+func TestWriteFolded(t *testing.T) {
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	writeFolded(w, "SUMMARY:"+strings.Repeat("ä", 60))
+	w.Flush()
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %q", len(lines), lines)
+	}
+	unfolded := lines[0] + strings.TrimPrefix(lines[1], " ")
+	if unfolded != "SUMMARY:"+strings.Repeat("ä", 60) {
+		t.Errorf("unfolded line = %q", unfolded)
+	}
+	for _, line := range lines {
+		if len(line) > 75 {
+			t.Errorf("line of %d octets", len(line))
+		}
+	}
+}
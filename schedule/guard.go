@@ -0,0 +1,303 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucy/slack-always-active/cache"
+)
+
+// defaultSessionBreak is the forced break after a session reaches
+// max_session.
+const defaultSessionBreak = 15 * time.Minute
+
+// limits caps the active time. Zero values mean no limit.
+type limits struct {
+	daily      time.Duration
+	weekly     time.Duration
+	maxSession time.Duration
+	// sessionBreak is the forced break after a long session. A shorter gap
+	// between connections does not end a session.
+	sessionBreak time.Duration
+}
+
+func (c *Config) parseLimits() (limits, error) {
+	var l limits
+	var err error
+	if l.daily, err = parseLimit(c.MaxActivePerDay); err != nil {
+		return l, fmt.Errorf("invalid daily limit: %v", err)
+	}
+	if l.weekly, err = parseLimit(c.MaxActivePerWeek); err != nil {
+		return l, fmt.Errorf("invalid weekly limit: %v", err)
+	}
+	if l.maxSession, err = parseLimit(c.MaxSession); err != nil {
+		return l, fmt.Errorf("invalid session limit: %v", err)
+	}
+	l.sessionBreak = defaultSessionBreak
+	if c.SessionBreak != "" {
+		if l.sessionBreak, err = parseLimit(c.SessionBreak); err != nil || l.sessionBreak == 0 {
+			return l, fmt.Errorf("invalid session break: %s is not a positive duration", c.SessionBreak)
+		}
+	}
+	return l, nil
+}
+
+func parseLimit(limitStr string) (time.Duration, error) {
+	if limitStr == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(limitStr)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s is not a positive duration", limitStr)
+	}
+	return d, nil
+}
+
+// formatLimit formats a duration without trailing zero units, such as "8h"
+// rather than "8h0m0s".
+func formatLimit(d time.Duration) string {
+	str := d.String()
+	if strings.HasSuffix(str, "m0s") {
+		str = strings.TrimSuffix(str, "0s")
+	}
+	if strings.HasSuffix(str, "h0m") {
+		str = strings.TrimSuffix(str, "0m")
+	}
+	return str
+}
+
+// UsageStore persists the accumulated active time across restarts.
+// *cache.Cache implements it.
+type UsageStore interface {
+	GetUsage() (*cache.Usage, error)
+	SetUsage(usage cache.Usage) error
+}
+
+// Guard enforces the daily and weekly active-time limits and the maximum
+// continuous session of a schedule. The supervisor reports when it
+// connects and disconnects, and asks the guard whether it may stay
+// connected.
+type Guard struct {
+	sched *Schedule
+	store UsageStore
+
+	mu    sync.Mutex
+	usage cache.Usage
+}
+
+// NewGuard creates a guard for the limits of sched and loads the active
+// time counted so far from store, which may be nil.
+func NewGuard(sched *Schedule, store UsageStore) (*Guard, error) {
+	g := &Guard{sched: sched, store: store}
+	if store == nil {
+		return g, nil
+	}
+
+	usage, err := store.GetUsage()
+	if err != nil {
+		return nil, err
+	}
+	if usage != nil {
+		g.usage = *usage
+		// The process stopped while connected; the time since the last
+		// update is not counted
+		g.usage.Connected = false
+	}
+	return g, nil
+}
+
+// dayKey and weekKey identify the day and the ISO week of t in loc.
+func dayKey(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format("2006-01-02")
+}
+
+func weekKey(t time.Time, loc *time.Location) string {
+	year, week := t.In(loc).ISOWeek()
+	return fmt.Sprintf("%04d-W%02d", year, week)
+}
+
+// count adds the active time from..to, split at local midnights so each
+// part is counted for its own day and week.
+func (g *Guard) count(from, to time.Time, loc *time.Location) {
+	for from.Before(to) {
+		local := from.In(loc)
+		end := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc)
+		if end.After(to) {
+			end = to
+		}
+
+		if day := dayKey(from, loc); day != g.usage.Day {
+			g.usage.Day, g.usage.DayActive = day, 0
+		}
+		if week := weekKey(from, loc); week != g.usage.Week {
+			g.usage.Week, g.usage.WeekActive = week, 0
+		}
+		g.usage.DayActive += end.Sub(from)
+		g.usage.WeekActive += end.Sub(from)
+		from = end
+	}
+}
+
+// update counts the active time up to t. g.mu must be held.
+func (g *Guard) update(t time.Time) {
+	if g.usage.Connected && t.After(g.usage.LastActive) {
+		g.count(g.usage.LastActive, t, g.sched.Location())
+		g.usage.LastActive = t
+	}
+}
+
+func (g *Guard) save() error {
+	if g.store == nil {
+		return nil
+	}
+	if err := g.store.SetUsage(g.usage); err != nil {
+		return fmt.Errorf("failed to save active time: %v", err)
+	}
+	return nil
+}
+
+// Connected records that a connection was made at t. A gap shorter than
+// the session break since the last connection continues the session.
+func (g *Guard) Connected(t time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.usage.Connected {
+		g.update(t)
+		return g.save()
+	}
+
+	l := g.sched.current().limits
+	if g.usage.SessionStart.IsZero() || t.Sub(g.usage.LastActive) >= l.sessionBreak {
+		g.usage.SessionStart = t
+	}
+	g.usage.Connected = true
+	g.usage.LastActive = t
+	return g.save()
+}
+
+// Update counts the active time up to t while connected.
+func (g *Guard) Update(t time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.usage.Connected {
+		return nil
+	}
+	g.update(t)
+	return g.save()
+}
+
+// Disconnected records that the connection was closed at t.
+func (g *Guard) Disconnected(t time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.usage.Connected {
+		return nil
+	}
+	g.update(t)
+	g.usage.Connected = false
+	return g.save()
+}
+
+// Check reports whether a connection is allowed at t. If not, it returns
+// the limit that was reached and when a connection is allowed again.
+func (g *Guard) Check(t time.Time) (bool, string, time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.update(t)
+
+	l := g.sched.current().limits
+	loc := g.sched.Location()
+	local := t.In(loc)
+
+	var dayActive, weekActive time.Duration
+	if g.usage.Day == dayKey(t, loc) {
+		dayActive = g.usage.DayActive
+	}
+	if g.usage.Week == weekKey(t, loc) {
+		weekActive = g.usage.WeekActive
+	}
+
+	if l.weekly > 0 && weekActive >= l.weekly {
+		daysToMonday := (8 - int(local.Weekday())) % 7
+		if daysToMonday == 0 {
+			daysToMonday = 7
+		}
+		resume := time.Date(local.Year(), local.Month(), local.Day()+daysToMonday, 0, 0, 0, 0, loc)
+		return false, fmt.Sprintf("weekly active-time limit of %s reached", formatLimit(l.weekly)), resume
+	}
+	if l.daily > 0 && dayActive >= l.daily {
+		resume := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc)
+		return false, fmt.Sprintf("daily active-time limit of %s reached", formatLimit(l.daily)), resume
+	}
+	if l.maxSession > 0 && !g.usage.SessionStart.IsZero() && g.usage.LastActive.Sub(g.usage.SessionStart) >= l.maxSession {
+		resume := g.usage.LastActive.Add(l.sessionBreak)
+		if g.usage.Connected {
+			resume = t.Add(l.sessionBreak)
+		}
+		if resume.After(t) {
+			return false, fmt.Sprintf("continuous session limit of %s reached, taking a %s break", formatLimit(l.maxSession), formatLimit(l.sessionBreak)), resume.In(loc)
+		}
+	}
+	return true, "", time.Time{}
+}
+
+// Deadline returns the earliest time a connection made or kept at t can
+// reach a limit, or the zero time if there are no limits. It may be early,
+// for example when a new day resets the daily time, but never late; check
+// again when it passes.
+func (g *Guard) Deadline(t time.Time) time.Time {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.update(t)
+
+	l := g.sched.current().limits
+	loc := g.sched.Location()
+
+	var deadline time.Time
+	earliest := func(d time.Time) {
+		if deadline.IsZero() || d.Before(deadline) {
+			deadline = d
+		}
+	}
+	if l.daily > 0 {
+		var dayActive time.Duration
+		if g.usage.Day == dayKey(t, loc) {
+			dayActive = g.usage.DayActive
+		}
+		earliest(t.Add(l.daily - dayActive))
+	}
+	if l.weekly > 0 {
+		var weekActive time.Duration
+		if g.usage.Week == weekKey(t, loc) {
+			weekActive = g.usage.WeekActive
+		}
+		earliest(t.Add(l.weekly - weekActive))
+	}
+	if l.maxSession > 0 {
+		start := g.usage.SessionStart
+		if !g.usage.Connected && (start.IsZero() || t.Sub(g.usage.LastActive) >= l.sessionBreak) {
+			start = t
+		}
+		earliest(start.Add(l.maxSession))
+	}
+	return deadline
+}
+
+// Usage returns the active time counted today and this week, as of t.
+func (g *Guard) Usage(t time.Time) (day, week time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.update(t)
+
+	loc := g.sched.Location()
+	if g.usage.Day == dayKey(t, loc) {
+		day = g.usage.DayActive
+	}
+	if g.usage.Week == weekKey(t, loc) {
+		week = g.usage.WeekActive
+	}
+	return day, week
+}
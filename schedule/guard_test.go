@@ -0,0 +1,140 @@
+package schedule
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lucy/slack-always-active/cache"
+)
+
+// memUsage keeps the active time in memory.
+type memUsage struct {
+	mu    sync.Mutex
+	usage *cache.Usage
+}
+
+func (m *memUsage) GetUsage() (*cache.Usage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.usage == nil {
+		return nil, nil
+	}
+	u := *m.usage
+	return &u, nil
+}
+
+func (m *memUsage) SetUsage(u cache.Usage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usage = &u
+	return nil
+}
+
+func TestParseLimits(t *testing.T) {
+	l, err := (&Config{MaxActivePerDay: "8h", MaxSession: "2h30m"}).parseLimits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.daily != 8*time.Hour || l.weekly != 0 || l.maxSession != 150*time.Minute || l.sessionBreak != defaultSessionBreak {
+		t.Errorf("parseLimits = %+v", l)
+	}
+
+	for _, config := range []Config{{MaxActivePerDay: "-1h"}, {MaxActivePerWeek: "lots"}, {SessionBreak: "0s"}} {
+		if _, err := config.parseLimits(); err == nil {
+			t.Errorf("parseLimits(%+v) should fail", config)
+		}
+	}
+}
+
+func TestGuardDailyLimit(t *testing.T) {
+	loc := mustLoad(t, "Europe/Berlin")
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 6, day, hour, minute, 0, 0, loc)
+	}
+	store := &memUsage{}
+	s := mustSchedule(t, &Config{Timezone: "Europe/Berlin", WorkHours: "mon-sun 00:00-24:00", MaxActivePerDay: "8h"})
+	g, err := NewGuard(s, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A session across midnight counts for both days
+	g.Connected(at(3, 22, 0))
+	g.Disconnected(at(4, 1, 0))
+	if day, week := g.Usage(at(4, 1, 0)); day != time.Hour || week != 3*time.Hour {
+		t.Errorf("Usage = %s, %s, want 1h, 3h", day, week)
+	}
+
+	g.Connected(at(4, 9, 0))
+	if got := g.Deadline(at(4, 9, 0)); !got.Equal(at(4, 16, 0)) {
+		t.Errorf("Deadline = %v, want 16:00", got)
+	}
+	allowed, reason, resume := g.Check(at(4, 16, 0))
+	if allowed || !strings.Contains(reason, "daily active-time limit of 8h") || !resume.Equal(at(5, 0, 0)) {
+		t.Errorf("Check = %v, %q, %v", allowed, reason, resume)
+	}
+	g.Disconnected(at(4, 16, 0))
+
+	// The counted time survives a restart
+	restarted, err := NewGuard(s, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed, _, _ := restarted.Check(at(4, 20, 0)); allowed {
+		t.Error("the daily limit should still apply after a restart")
+	}
+	if allowed, _, _ := restarted.Check(at(5, 0, 0)); !allowed {
+		t.Error("a new day should reset the daily limit")
+	}
+}
+
+func TestGuardWeeklyLimit(t *testing.T) {
+	loc := mustLoad(t, "Europe/Berlin")
+	s := mustSchedule(t, &Config{Timezone: "Europe/Berlin", WorkHours: "mon-sun 00:00-24:00", MaxActivePerWeek: "20h"})
+	g, _ := NewGuard(s, nil)
+
+	// Monday 2024-06-03 to Wednesday noon is more than 20 hours
+	g.Connected(time.Date(2024, 6, 3, 0, 0, 0, 0, loc))
+	g.Update(time.Date(2024, 6, 3, 20, 0, 0, 0, loc))
+	allowed, _, resume := g.Check(time.Date(2024, 6, 3, 20, 0, 0, 0, loc))
+	if allowed || !resume.Equal(time.Date(2024, 6, 10, 0, 0, 0, 0, loc)) {
+		t.Errorf("Check = %v, %v, want blocked until next Monday", allowed, resume)
+	}
+}
+
+func TestGuardSessionLimit(t *testing.T) {
+	loc := mustLoad(t, "Europe/Berlin")
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 6, 3, hour, minute, 0, 0, loc)
+	}
+	s := mustSchedule(t, &Config{Timezone: "Europe/Berlin", WorkHours: "mon-sun 00:00-24:00", MaxSession: "2h", SessionBreak: "15m"})
+	g, _ := NewGuard(s, nil)
+
+	g.Connected(at(9, 0))
+	// A short gap does not end the session
+	g.Disconnected(at(10, 0))
+	g.Connected(at(10, 10))
+	if got := g.Deadline(at(10, 10)); !got.Equal(at(11, 0)) {
+		t.Errorf("Deadline = %v, want 11:00", got)
+	}
+
+	allowed, reason, resume := g.Check(at(11, 0))
+	if allowed || !strings.Contains(reason, "15m break") || !resume.Equal(at(11, 15)) {
+		t.Errorf("Check = %v, %q, %v", allowed, reason, resume)
+	}
+	g.Disconnected(at(11, 0))
+	if allowed, _, _ := g.Check(at(11, 14)); allowed {
+		t.Error("the break should last 15 minutes")
+	}
+	if allowed, _, _ := g.Check(at(11, 15)); !allowed {
+		t.Error("the break should be over at 11:15")
+	}
+
+	// After the break a new session starts
+	g.Connected(at(11, 15))
+	if got := g.Deadline(at(11, 15)); !got.Equal(at(13, 15)) {
+		t.Errorf("Deadline = %v, want 13:15", got)
+	}
+}
@@ -0,0 +1,370 @@
+package schedule
+
+import (
+	_ "embed"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed holidays.txt
+var holidayRulesText string
+
+// observance moves a holiday that falls on a weekend.
+type observance int
+
+const (
+	observeNone observance = iota
+	// observeNearest moves Saturday to Friday and Sunday to Monday.
+	observeNearest
+	// observeSubstitute moves to the next weekday that is not a holiday.
+	observeSubstitute
+)
+
+// holidayRule computes the date of a holiday in a given year.
+type holidayRule struct {
+	country  string
+	name     string
+	observed observance
+
+	// A fixed date when weekday is unset, otherwise the nth weekday of
+	// month; a negative nth counts from the end of the month.
+	month   time.Month
+	day     int
+	weekday *time.Weekday
+	nth     int
+
+	// An offset from Easter Sunday when easter is set.
+	easter func(year int) date
+	offset int
+}
+
+// dateIn returns the date of the holiday in year, before observed-day
+// shifting.
+func (r holidayRule) dateIn(year int) date {
+	switch {
+	case r.easter != nil:
+		e := r.easter(year)
+		return dateOn(e.year, e.month, e.day+r.offset)
+	case r.weekday != nil:
+		if r.nth > 0 {
+			first := time.Date(year, r.month, 1, 12, 0, 0, 0, time.UTC)
+			day := 1 + (int(*r.weekday)-int(first.Weekday())+7)%7 + 7*(r.nth-1)
+			return dateOn(year, r.month, day)
+		}
+		last := time.Date(year, r.month+1, 0, 12, 0, 0, 0, time.UTC)
+		day := last.Day() - (int(last.Weekday())-int(*r.weekday)+7)%7 + 7*(r.nth+1)
+		return dateOn(year, r.month, day)
+	default:
+		return date{year, r.month, r.day}
+	}
+}
+
+// westernEaster returns Easter Sunday in the Gregorian calendar, using the
+// anonymous Gregorian algorithm.
+func westernEaster(year int) date {
+	a := year % 19
+	b, c := year/100, year%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return date{year, time.Month(month), day}
+}
+
+// orthodoxEaster returns Orthodox Easter Sunday as a Gregorian date, using
+// the Meeus Julian algorithm. The Julian calendar is 13 days behind from
+// 1900 to 2099.
+func orthodoxEaster(year int) date {
+	a, b, c := year%4, year%7, year%19
+	d := (19*c + 15) % 30
+	e := (2*a + 4*b - d + 34) % 7
+	month := (d + e + 114) / 31
+	day := (d+e+114)%31 + 1
+	return dateOn(year, time.Month(month), day+13)
+}
+
+// parseHolidayRules parses rules in the format of holidays.txt.
+func parseHolidayRules(text string) ([]holidayRule, error) {
+	var rules []holidayRule
+	for n, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseHolidayRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n+1, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseHolidayRule(line string) (holidayRule, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return holidayRule{}, fmt.Errorf("invalid holiday rule %q: expected country, date and name", line)
+	}
+	rule := holidayRule{country: strings.ToUpper(fields[0])}
+
+	nameFields := fields[2:]
+	if policy, ok := strings.CutPrefix(nameFields[0], "observed="); ok {
+		switch policy {
+		case "nearest":
+			rule.observed = observeNearest
+		case "substitute":
+			rule.observed = observeSubstitute
+		default:
+			return holidayRule{}, fmt.Errorf("invalid holiday rule %q: unknown observed policy %q", line, policy)
+		}
+		nameFields = nameFields[1:]
+	}
+	if len(nameFields) == 0 {
+		return holidayRule{}, fmt.Errorf("invalid holiday rule %q: missing name", line)
+	}
+	rule.name = strings.Join(nameFields, " ")
+
+	if err := rule.parseDate(fields[1]); err != nil {
+		return holidayRule{}, fmt.Errorf("invalid holiday rule %q: %v", line, err)
+	}
+	return rule, nil
+}
+
+func (r *holidayRule) parseDate(dateStr string) error {
+	for prefix, easter := range map[string]func(int) date{"easter": westernEaster, "orthodox": orthodoxEaster} {
+		offsetStr, ok := strings.CutPrefix(dateStr, prefix)
+		if !ok {
+			continue
+		}
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil {
+			return fmt.Errorf("invalid Easter offset %q", offsetStr)
+		}
+		r.easter, r.offset = easter, offset
+		return nil
+	}
+
+	if monthStr, dayStr, ok := strings.Cut(dateStr, "/"); ok {
+		month, err := strconv.Atoi(monthStr)
+		if err != nil || month < 1 || month > 12 {
+			return fmt.Errorf("invalid month %q", monthStr)
+		}
+		weekdayStr, nthStr, ok := strings.Cut(dayStr, "#")
+		weekday, known := dayNames[strings.ToLower(weekdayStr)]
+		if !ok || !known {
+			return fmt.Errorf("invalid weekday %q: expected a day such as MON#3", dayStr)
+		}
+		nth, err := strconv.Atoi(nthStr)
+		if err != nil || nth == 0 || nth < -5 || nth > 5 {
+			return fmt.Errorf("invalid weekday number %q", nthStr)
+		}
+		r.month, r.weekday, r.nth = time.Month(month), &weekday, nth
+		return nil
+	}
+
+	t, err := time.Parse("01-02", dateStr)
+	if err != nil {
+		return fmt.Errorf("invalid date %q", dateStr)
+	}
+	r.month, r.day = t.Month(), t.Day()
+	return nil
+}
+
+// holidayRulesFor returns the built-in rules of the given countries.
+func holidayRulesFor(countries []string) ([]holidayRule, error) {
+	all, err := parseHolidayRules(holidayRulesText)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool)
+	for _, rule := range all {
+		known[rule.country] = true
+	}
+
+	var rules []holidayRule
+	for _, country := range countries {
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if !known[country] {
+			return nil, fmt.Errorf("no holiday rules for country %q (available: %s)", country, strings.Join(sortedKeys(known), ", "))
+		}
+		for _, rule := range all {
+			if rule.country == country {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules, nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// holidays computes public holidays from rules. Each holiday blocks the
+// whole day in the schedule location, as well as the day it is observed
+// on when it falls on a weekend.
+type holidays struct {
+	rules []holidayRule
+	loc   *time.Location
+
+	mu    sync.Mutex
+	years map[int]map[date]string
+}
+
+func newHolidays(loc *time.Location, countries []string) (*holidays, error) {
+	rules, err := holidayRulesFor(countries)
+	if err != nil {
+		return nil, err
+	}
+	return &holidays{rules: rules, loc: loc, years: make(map[int]map[date]string)}, nil
+}
+
+// computeYear returns the holidays of the rules for year, including the
+// observed days, which may fall into an adjacent year.
+func (h *holidays) computeYear(year int) map[date]string {
+	// The lock is not held while computing, since a substitute day moving
+	// into the next year needs that year's holidays
+	h.mu.Lock()
+	days, ok := h.years[year]
+	h.mu.Unlock()
+	if ok {
+		return days
+	}
+
+	days = make(map[date]string)
+	add := func(d date, name string) {
+		if existing, ok := days[d]; ok && !strings.Contains(existing, name) {
+			name = existing + ", " + name
+		}
+		days[d] = name
+	}
+
+	// Actual dates come first, so substitute days skip them
+	var shifted []holidayRule
+	for _, rule := range h.rules {
+		d := rule.dateIn(year)
+		add(d, fmt.Sprintf("%s (%s)", rule.name, rule.country))
+		if rule.observed != observeNone && d.isWeekend() {
+			shifted = append(shifted, rule)
+		}
+	}
+
+	for _, rule := range shifted {
+		d := rule.dateIn(year)
+		name := fmt.Sprintf("%s (%s, observed)", rule.name, rule.country)
+		switch rule.observed {
+		case observeNearest:
+			if d.weekday() == time.Saturday {
+				add(dateOn(d.year, d.month, d.day-1), name)
+			} else {
+				add(dateOn(d.year, d.month, d.day+1), name)
+			}
+		case observeSubstitute:
+			next := dateOn(d.year, d.month, d.day+1)
+			for next.isWeekend() || days[next] != "" || (next.year != year && h.computeYear(next.year)[next] != "") {
+				next = dateOn(next.year, next.month, next.day+1)
+			}
+			add(next, name)
+		}
+	}
+
+	h.mu.Lock()
+	h.years[year] = days
+	h.mu.Unlock()
+	return days
+}
+
+func (d date) weekday() time.Weekday {
+	return time.Date(d.year, d.month, d.day, 12, 0, 0, 0, time.UTC).Weekday()
+}
+
+func (d date) isWeekend() bool {
+	return d.weekday() == time.Saturday || d.weekday() == time.Sunday
+}
+
+// holidayOn returns the name of the holiday on d, if any.
+func (h *holidays) holidayOn(d date) (string, bool) {
+	if h == nil {
+		return "", false
+	}
+	// Observed days may come from the rules of an adjacent year
+	for year := d.year - 1; year <= d.year+1; year++ {
+		if name, ok := h.computeYear(year)[d]; ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// dayAt returns the local day that contains t.
+func (h *holidays) dayAt(t time.Time) (date, interval) {
+	local := t.In(h.loc)
+	y, m, d := local.Date()
+	return date{y, m, d}, interval{
+		start: time.Date(y, m, d, 0, 0, 0, 0, h.loc),
+		end:   time.Date(y, m, d+1, 0, 0, 0, 0, h.loc),
+	}
+}
+
+// blockedUntil reports whether t falls on a holiday and, if so, when the
+// holidays in a row that cover it end.
+func (h *holidays) blockedUntil(t time.Time) (time.Time, bool) {
+	if h == nil {
+		return time.Time{}, false
+	}
+	d, day := h.dayAt(t)
+	if _, ok := h.holidayOn(d); !ok {
+		return time.Time{}, false
+	}
+	until := day.end
+	for i := 0; i < maxSearch; i++ {
+		next, nextDay := h.dayAt(until)
+		if _, ok := h.holidayOn(next); !ok {
+			break
+		}
+		until = nextDay.end
+	}
+	return until, true
+}
+
+// holidayAt returns the name and day of the holiday t falls on, if any.
+func (h *holidays) holidayAt(t time.Time) (string, interval, bool) {
+	if h == nil {
+		return "", interval{}, false
+	}
+	d, day := h.dayAt(t)
+	name, ok := h.holidayOn(d)
+	return name, day, ok
+}
+
+// nextBlockStart returns the start of the first holiday after t, or the
+// zero time if there is none within a year.
+func (h *holidays) nextBlockStart(t time.Time) time.Time {
+	if h == nil {
+		return time.Time{}
+	}
+	_, day := h.dayAt(t)
+	for i := 0; i < 400; i++ {
+		var d date
+		d, day = h.dayAt(day.end)
+		if _, ok := h.holidayOn(d); ok {
+			return day.start
+		}
+	}
+	return time.Time{}
+}
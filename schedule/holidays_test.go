@@ -0,0 +1,174 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEaster(t *testing.T) {
+	tests := []struct {
+		year              int
+		western, orthodox date
+	}{
+		{2023, date{2023, 4, 9}, date{2023, 4, 16}},
+		{2024, date{2024, 3, 31}, date{2024, 5, 5}},
+		{2025, date{2025, 4, 20}, date{2025, 4, 20}},
+		{2026, date{2026, 4, 5}, date{2026, 4, 12}},
+	}
+	for _, tt := range tests {
+		if got := westernEaster(tt.year); got != tt.western {
+			t.Errorf("westernEaster(%d) = %s, want %s", tt.year, got, tt.western)
+		}
+		if got := orthodoxEaster(tt.year); got != tt.orthodox {
+			t.Errorf("orthodoxEaster(%d) = %s, want %s", tt.year, got, tt.orthodox)
+		}
+	}
+}
+
+func TestHolidayRuleDates(t *testing.T) {
+	tests := []struct {
+		rule string
+		year int
+		want date
+	}{
+		{"US 11/THU#4 Thanksgiving Day", 2024, date{2024, 11, 28}},
+		{"US 05/MON#-1 Memorial Day", 2024, date{2024, 5, 27}},
+		{"US 09/MON#1 Labor Day", 2024, date{2024, 9, 2}},
+		{"GB 08/MON#-1 Summer Bank Holiday", 2025, date{2025, 8, 25}},
+		{"DE easter-2 Good Friday", 2024, date{2024, 3, 29}},
+		{"DE easter+39 Ascension Day", 2024, date{2024, 5, 9}},
+		{"UA orthodox+49 Trinity", 2024, date{2024, 6, 23}},
+		{"DE 10-03 German Unity Day", 2024, date{2024, 10, 3}},
+	}
+	for _, tt := range tests {
+		rule, err := parseHolidayRule(tt.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rule.dateIn(tt.year); got != tt.want {
+			t.Errorf("%q in %d = %s, want %s", tt.rule, tt.year, got, tt.want)
+		}
+	}
+
+	for _, invalid := range []string{
+		"DE 13-01 Nothing",
+		"DE 05/MON Missing number",
+		"DE 05/XYZ#1 Unknown day",
+		"DE easter+x Bad offset",
+		"DE 12-25 observed=later Christmas",
+		"DE 12-25",
+	} {
+		if _, err := parseHolidayRule(invalid); err == nil {
+			t.Errorf("parseHolidayRule(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestBuiltInHolidayRules(t *testing.T) {
+	if _, err := parseHolidayRules(holidayRulesText); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newHolidays(time.UTC, []string{"de", "UA"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newHolidays(time.UTC, []string{"XX"}); err == nil || !strings.Contains(err.Error(), "available") {
+		t.Errorf("unknown country: got %v", err)
+	}
+}
+
+func TestObservedHolidays(t *testing.T) {
+	h, err := newHolidays(time.UTC, []string{"US", "GB"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		day  date
+		want string
+	}{
+		// New Year's Day 2022 is a Saturday, observed in the previous year
+		{date{2021, 12, 31}, "New Year's Day (US, observed)"},
+		// and on the next Monday in Britain
+		{date{2022, 1, 3}, "New Year's Day (GB, observed)"},
+		// Christmas 2022 is a Sunday: Boxing Day keeps its Monday and
+		// Christmas moves to Tuesday in Britain
+		{date{2022, 12, 26}, "Boxing Day (GB)"},
+		{date{2022, 12, 27}, "Christmas Day (GB, observed)"},
+		// Independence Day 2026 is a Saturday
+		{date{2026, 7, 3}, "Independence Day (US, observed)"},
+	}
+	for _, tt := range tests {
+		name, ok := h.holidayOn(tt.day)
+		if !ok || !strings.Contains(name, tt.want) {
+			t.Errorf("holidayOn(%s) = %q, %v, want %q", tt.day, name, ok, tt.want)
+		}
+	}
+	if name, ok := h.holidayOn(date{2022, 12, 28}); ok {
+		t.Errorf("2022-12-28 should not be a holiday, got %q", name)
+	}
+}
+
+func TestSubstituteIntoNextYear(t *testing.T) {
+	rules, err := parseHolidayRules(`
+XX 12-25 observed=substitute Christmas Day
+XX 12-26 observed=substitute Boxing Day
+XX 12-31 observed=substitute New Year's Eve
+XX 01-01 observed=substitute New Year's Day
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &holidays{rules: rules, loc: time.UTC, years: make(map[int]map[date]string)}
+
+	// Christmas 2022 is a Sunday and New Year's Eve a Saturday. New Year's
+	// Day 2023 is a Sunday and keeps the Monday, so New Year's Eve moves on
+	// to Tuesday.
+	tests := []struct {
+		day  date
+		want string
+	}{
+		{date{2022, 12, 26}, "Boxing Day (XX)"},
+		{date{2022, 12, 27}, "Christmas Day (XX, observed)"},
+		{date{2022, 12, 31}, "New Year's Eve (XX)"},
+		{date{2023, 1, 1}, "New Year's Day (XX)"},
+		{date{2023, 1, 2}, "New Year's Day (XX, observed)"},
+		{date{2023, 1, 3}, "New Year's Eve (XX, observed)"},
+	}
+	for _, tt := range tests {
+		name, ok := h.holidayOn(tt.day)
+		if !ok || name != tt.want {
+			t.Errorf("holidayOn(%s) = %q, %v, want %q", tt.day, name, ok, tt.want)
+		}
+	}
+	for _, day := range []date{{2022, 12, 28}, {2023, 1, 4}} {
+		if name, ok := h.holidayOn(day); ok {
+			t.Errorf("%s should not be a holiday, got %q", day, name)
+		}
+	}
+}
+
+func TestScheduleSkipsHolidays(t *testing.T) {
+	loc := mustLoad(t, "Europe/Berlin")
+	s := mustSchedule(t, &Config{Timezone: "Europe/Berlin", WorkHours: "mon-fri 09:00-17:00", Holidays: []string{"DE"}})
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2024, month, day, hour, 0, 0, 0, loc)
+	}
+
+	// Good Friday and Easter Monday 2024
+	if s.IsWorkingTimeAt(at(3, 29, 10)) || s.IsWorkingTimeAt(at(4, 1, 10)) {
+		t.Error("Easter holidays should not be working time")
+	}
+	if got := s.GetNextWorkingTimeAt(at(3, 28, 18)); !got.Equal(at(4, 2, 9)) {
+		t.Errorf("GetNextWorkingTimeAt = %v, want Tuesday after Easter", got)
+	}
+	// Working time on the day before German Unity Day ends as usual
+	if got := s.NextTransitionAt(at(10, 2, 10)); !got.Equal(at(10, 2, 17)) {
+		t.Errorf("NextTransitionAt = %v, want 17:00", got)
+	}
+
+	explanation := s.Explain(at(10, 3, 10)).String()
+	if !strings.Contains(explanation, "German Unity Day") {
+		t.Errorf("Explain does not mention the holiday:\n%s", explanation)
+	}
+}
@@ -0,0 +1,272 @@
+package schedule
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// defaultJitterSeed is used when no JITTER_SEED is configured.
+const defaultJitterSeed = "slack-always-active"
+
+// maxJitter bounds the configurable start and end jitter.
+const maxJitter = 2 * time.Hour
+
+// breakMargin keeps coffee breaks away from the edges of a working period.
+const breakMargin = 30 * time.Minute
+
+// jitter shifts the start and end of working periods by a random amount and
+// inserts short coffee breaks. All randomness is derived from the seed and
+// the local date, so a given day always gets the same jitter, also across
+// restarts, and GetNextWorkingTime reports the times that are acted on.
+type jitter struct {
+	start    time.Duration
+	end      time.Duration
+	seed     string
+	breaks   int
+	breakMin time.Duration
+	breakMax time.Duration
+}
+
+func (c *Config) parseJitter() (*jitter, error) {
+	j := &jitter{seed: c.JitterSeed, breaks: c.CoffeeBreaks}
+	if j.seed == "" {
+		j.seed = defaultJitterSeed
+	}
+
+	var err error
+	if j.start, err = parseJitterDuration(c.JitterStart); err != nil {
+		return nil, fmt.Errorf("invalid start jitter: %v", err)
+	}
+	if j.end, err = parseJitterDuration(c.JitterEnd); err != nil {
+		return nil, fmt.Errorf("invalid end jitter: %v", err)
+	}
+
+	if j.breaks < 0 {
+		return nil, fmt.Errorf("invalid number of coffee breaks: %d", j.breaks)
+	}
+	if j.breaks > 0 {
+		if j.breakMin, j.breakMax, err = parseBreakDuration(c.CoffeeBreakTime); err != nil {
+			return nil, fmt.Errorf("invalid coffee break duration: %v", err)
+		}
+	}
+
+	if j.start == 0 && j.end == 0 && j.breaks == 0 {
+		return nil, nil
+	}
+	return j, nil
+}
+
+func parseJitterDuration(durationStr string) (time.Duration, error) {
+	if durationStr == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(durationStr)
+	if err != nil || d < 0 || d > maxJitter {
+		return 0, fmt.Errorf("%s is not a duration between 0 and %s", durationStr, maxJitter)
+	}
+	return d.Truncate(time.Second), nil
+}
+
+// parseBreakDuration parses a break length such as "10m" or a range such as
+// "5m-15m". It defaults to 5 to 15 minutes.
+func parseBreakDuration(durationStr string) (time.Duration, time.Duration, error) {
+	if durationStr == "" {
+		durationStr = "5m-15m"
+	}
+	minStr, maxStr, isRange := strings.Cut(durationStr, "-")
+	if !isRange {
+		maxStr = minStr
+	}
+
+	min, err := time.ParseDuration(strings.TrimSpace(minStr))
+	if err != nil || min <= 0 {
+		return 0, 0, fmt.Errorf("invalid duration: %s", minStr)
+	}
+	max, err := time.ParseDuration(strings.TrimSpace(maxStr))
+	if err != nil || max < min {
+		return 0, 0, fmt.Errorf("invalid duration: %s", maxStr)
+	}
+	return min.Truncate(time.Second), max.Truncate(time.Second), nil
+}
+
+// reach is the furthest a boundary can move.
+func (j *jitter) reach() time.Duration {
+	if j.start > j.end {
+		return j.start
+	}
+	return j.end
+}
+
+// random returns a generator seeded by the seed, the local date of t and a
+// label, so each boundary of each day draws its own stable values.
+func (j *jitter) random(t time.Time, label string) *rand.Rand {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|%s|%s", j.seed, t.Format("2006-01-02"), label)
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+// uniform draws a duration between min and max with one second precision.
+func uniform(r *rand.Rand, min, max time.Duration) time.Duration {
+	seconds := int64((max - min) / time.Second)
+	return min + time.Duration(r.Int63n(seconds+1))*time.Second
+}
+
+// shift returns how far the unjittered transition at t moves.
+func (j *jitter) shift(p *plan, t time.Time, starts bool) time.Duration {
+	max, label := j.end, "end"
+	if starts {
+		max, label = j.start, "start"
+	}
+	if max == 0 {
+		return 0
+	}
+	local := t.In(p.loc)
+	return uniform(j.random(local, label+" "+local.Format("15:04:05")), -max, max)
+}
+
+// shiftedWorkingAt reports whether t is working time once the transitions
+// are shifted, ignoring breaks. Only transitions within reach of t can
+// change the answer.
+func (j *jitter) shiftedWorkingAt(p *plan, t time.Time) bool {
+	from := t.Add(-j.reach())
+	working := p.rawWorkingAt(from)
+
+	next := p.rawNextTransitionAt(from)
+	for i := 0; i < maxSearch && !next.IsZero() && !next.After(t.Add(j.reach())); i++ {
+		starts := p.rawWorkingAt(next)
+		if !next.Add(j.shift(p, next, starts)).After(t) {
+			working = starts
+		}
+		next = p.rawNextTransitionAt(next)
+	}
+	return working
+}
+
+// breaksOn returns the coffee breaks of the given local day. They are spread
+// over the day's working time, away from the edges of each working period.
+func (j *jitter) breaksOn(p *plan, year int, month time.Month, day int) []interval {
+	if j.breaks == 0 {
+		return nil
+	}
+	dayStart := time.Date(year, month, day, 0, 0, 0, 0, p.loc)
+	dayEnd := time.Date(year, month, day+1, 0, 0, 0, 0, p.loc)
+
+	// Collect the parts of the day long enough to hold a break
+	margin := breakMargin + j.reach()
+	var usable []interval
+	var total time.Duration
+	t, working := dayStart, p.rawWorkingAt(dayStart)
+	for i := 0; i < maxSearch && t.Before(dayEnd); i++ {
+		next := p.rawNextTransitionAt(t)
+		if next.IsZero() || next.After(dayEnd) {
+			next = dayEnd
+		}
+		if start, end := t.Add(margin), next.Add(-margin); working && end.Sub(start) >= j.breakMax {
+			usable = append(usable, interval{start: start, end: end})
+			total += end.Sub(start)
+		}
+		t, working = next, p.rawWorkingAt(next)
+	}
+	if total == 0 {
+		return nil
+	}
+
+	// Place one break at a random spot in each of equal slots of the usable time
+	r := j.random(dayStart, "breaks")
+	slot := total / time.Duration(j.breaks)
+	var breaks []interval
+	for i := 0; i < j.breaks; i++ {
+		length := uniform(r, j.breakMin, j.breakMax)
+		if slot < length {
+			continue
+		}
+		offset := time.Duration(i)*slot + uniform(r, 0, slot-length)
+
+		for _, u := range usable {
+			if size := u.end.Sub(u.start); offset >= size {
+				offset -= size
+				continue
+			}
+			start := u.start.Add(offset)
+			if start.Add(length).After(u.end) {
+				start = u.end.Add(-length)
+			}
+			breaks = append(breaks, interval{start: start, end: start.Add(length)})
+			break
+		}
+	}
+	return breaks
+}
+
+func (j *jitter) inBreak(p *plan, t time.Time) bool {
+	local := t.In(p.loc)
+	for _, b := range j.breaksOn(p, local.Year(), local.Month(), local.Day()) {
+		if b.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *jitter) workingAt(p *plan, t time.Time) bool {
+	return j.shiftedWorkingAt(p, t) && !j.inBreak(p, t)
+}
+
+// nextCandidate returns the earliest time after t at which a shifted
+// transition or a break boundary lies.
+func (j *jitter) nextCandidate(p *plan, t time.Time) time.Time {
+	var earliest time.Time
+	consider := func(c time.Time) {
+		if c.After(t) && (earliest.IsZero() || c.Before(earliest)) {
+			earliest = c
+		}
+	}
+
+	// Transitions further than reach past t cannot move before t
+	next := p.rawNextTransitionAt(t.Add(-j.reach()))
+	for i := 0; i < maxSearch && !next.IsZero(); i++ {
+		consider(next.Add(j.shift(p, next, p.rawWorkingAt(next))))
+		if next.After(t.Add(j.reach())) {
+			break
+		}
+		next = p.rawNextTransitionAt(next)
+	}
+
+	local := t.In(p.loc)
+	for i := 0; i <= 7; i++ {
+		dayStart := time.Date(local.Year(), local.Month(), local.Day()+i, 0, 0, 0, 0, p.loc)
+		if !earliest.IsZero() && dayStart.After(earliest) {
+			break
+		}
+		for _, b := range j.breaksOn(p, dayStart.Year(), dayStart.Month(), dayStart.Day()) {
+			consider(b.start)
+			consider(b.end)
+		}
+	}
+	return earliest
+}
+
+func (j *jitter) nextTransitionAt(p *plan, t time.Time) time.Time {
+	working := j.workingAt(p, t)
+	for i := 0; i < maxSearch; i++ {
+		next := j.nextCandidate(p, t)
+		if next.IsZero() {
+			return next
+		}
+		if j.workingAt(p, next) != working {
+			return next.In(p.loc)
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+func (j *jitter) nextWorkingAt(p *plan, t time.Time) time.Time {
+	if j.workingAt(p, t) {
+		return t.In(p.loc)
+	}
+	return j.nextTransitionAt(p, t)
+}
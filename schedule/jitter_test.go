@@ -0,0 +1,77 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitterTransitions(t *testing.T) {
+	config := &Config{
+		WorkHours:    "mon-fri 09:00-18:00",
+		JitterStart:  "10m",
+		JitterEnd:    "10m",
+		JitterSeed:   "test",
+		CoffeeBreaks: 2,
+	}
+	s := mustSchedule(t, config)
+
+	// Walk a week of transitions; 2024-06-03 is a Monday
+	now := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)
+	end := now.AddDate(0, 0, 7)
+	var starts, ends, breaks int
+	for {
+		next := s.NextTransitionAt(now)
+		if next.IsZero() || next.After(end) {
+			break
+		}
+		before, after := s.IsWorkingTimeAt(next.Add(-time.Second)), s.IsWorkingTimeAt(next)
+		if before == after {
+			t.Fatalf("no change at transition %s", next)
+		}
+		if !after && s.GetNextWorkingTimeAt(next).Equal(next) {
+			t.Errorf("GetNextWorkingTimeAt(%s) returned a non-working time", next)
+		}
+		if after && !s.GetNextWorkingTimeAt(now).Equal(next) {
+			t.Errorf("GetNextWorkingTimeAt(%s) = %s, want %s", now, s.GetNextWorkingTimeAt(now), next)
+		}
+
+		sinceMidnight := next.Sub(time.Date(next.Year(), next.Month(), next.Day(), 0, 0, 0, 0, time.UTC))
+		switch {
+		case after && sinceMidnight >= 9*time.Hour-10*time.Minute && sinceMidnight <= 9*time.Hour+10*time.Minute:
+			starts++
+		case !after && sinceMidnight >= 18*time.Hour-10*time.Minute && sinceMidnight <= 18*time.Hour+10*time.Minute:
+			ends++
+		default:
+			// A coffee break starts or ends well inside the working day
+			if sinceMidnight < 9*time.Hour+40*time.Minute || sinceMidnight > 18*time.Hour-40*time.Minute {
+				t.Errorf("unexpected transition at %s", next)
+			}
+			breaks++
+		}
+		now = next
+	}
+
+	if starts != 5 || ends != 5 || breaks != 2*2*5 {
+		t.Errorf("got %d starts, %d ends and %d break transitions, want 5, 5 and 20", starts, ends, breaks)
+	}
+
+	// A restart with the same seed gives the same times
+	again := mustSchedule(t, config)
+	monday := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)
+	if a, b := s.NextTransitionAt(monday), again.NextTransitionAt(monday); !a.Equal(b) {
+		t.Errorf("jitter differs after restart: %s and %s", a, b)
+	}
+}
+
+func TestParseJitterErrors(t *testing.T) {
+	for _, config := range []*Config{
+		{WorkHours: "mon 09:00-18:00", JitterStart: "-5m"},
+		{WorkHours: "mon 09:00-18:00", JitterEnd: "3h"},
+		{WorkHours: "mon 09:00-18:00", CoffeeBreaks: -1},
+		{WorkHours: "mon 09:00-18:00", CoffeeBreaks: 1, CoffeeBreakTime: "15m-5m"},
+	} {
+		if _, err := NewScheduleFromConfig(config); err == nil {
+			t.Errorf("NewScheduleFromConfig(%+v) succeeded, want error", config)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lucy/slack-always-active/cache"
+	"github.com/lucy/slack-always-active/logger"
+)
+
+// OverrideMode is the state an override forces.
+type OverrideMode string
+
+const (
+	ForceActive OverrideMode = "active"
+	ForceAway   OverrideMode = "away"
+)
+
+// Override forces working or non-working time from From until Until,
+// regardless of the schedule.
+type Override struct {
+	Mode  OverrideMode
+	From  time.Time
+	Until time.Time
+}
+
+// activeAt reports whether the override applies at t. A nil override never
+// applies.
+func (o *Override) activeAt(t time.Time) bool {
+	return o != nil && !t.Before(o.From) && t.Before(o.Until)
+}
+
+func (o *Override) describe(loc *time.Location) string {
+	if o.Mode == ForceActive {
+		return fmt.Sprintf("override keeps you active until %s", formatTime(o.Until.In(loc)))
+	}
+	return fmt.Sprintf("override keeps you away until %s", formatTime(o.Until.In(loc)))
+}
+
+// OverrideStore persists the override across restarts. *cache.Cache
+// implements it.
+type OverrideStore interface {
+	GetOverride() (*cache.Override, error)
+	SetOverride(override cache.Override) error
+	ClearOverride() error
+}
+
+// effective is the plan in effect with the override applied on top.
+type effective struct {
+	plan     *plan
+	override *Override
+}
+
+func (s *Schedule) effective() effective {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return effective{plan: s.plan, override: s.override}
+}
+
+func (e effective) workingAt(t time.Time) bool {
+	if e.override.activeAt(t) {
+		return e.override.Mode == ForceActive
+	}
+	return e.plan.isWorkingTimeAt(t)
+}
+
+func (e effective) nextWorkingAt(t time.Time) time.Time {
+	if e.override == nil || !t.Before(e.override.Until) {
+		return e.plan.nextWorkingTimeAt(t)
+	}
+	if e.workingAt(t) {
+		return t.In(e.plan.loc)
+	}
+	return e.nextTransitionAt(t)
+}
+
+func (e effective) nextTransitionAt(t time.Time) time.Time {
+	o := e.override
+	if o == nil || !t.Before(o.Until) {
+		return e.plan.nextTransitionAt(t)
+	}
+
+	// Step through the schedule transitions and the override bounds until
+	// the result actually changes
+	working := e.workingAt(t)
+	for i := 0; i < maxSearch; i++ {
+		next := e.plan.nextTransitionAt(t)
+		for _, bound := range []time.Time{o.From, o.Until} {
+			if bound.After(t) && (next.IsZero() || bound.Before(next)) {
+				next = bound
+			}
+		}
+		if next.IsZero() {
+			return next
+		}
+		if e.workingAt(next) != working {
+			return next.In(e.plan.loc)
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+// ForceActiveUntil keeps the schedule in working time from now until the
+// given time, replacing any previous override.
+func (s *Schedule) ForceActiveUntil(until time.Time) error {
+	return s.setOverride(ForceActive, until)
+}
+
+// ForceAwayUntil keeps the schedule outside working time from now until the
+// given time, replacing any previous override.
+func (s *Schedule) ForceAwayUntil(until time.Time) error {
+	return s.setOverride(ForceAway, until)
+}
+
+func (s *Schedule) setOverride(mode OverrideMode, until time.Time) error {
+	now := s.now()
+	if !until.After(now) {
+		return fmt.Errorf("override end %s is not in the future", formatTime(until.In(s.Location())))
+	}
+
+	o := &Override{Mode: mode, From: now, Until: until}
+	if store := s.overrideStore(); store != nil {
+		if err := store.SetOverride(cache.Override{Mode: string(mode), From: o.From, Until: o.Until}); err != nil {
+			return fmt.Errorf("failed to save override: %v", err)
+		}
+	}
+	s.swapOverride(o)
+	return nil
+}
+
+// ClearOverride removes the override, so the schedule applies again.
+func (s *Schedule) ClearOverride() error {
+	if store := s.overrideStore(); store != nil {
+		if err := store.ClearOverride(); err != nil {
+			return fmt.Errorf("failed to clear override: %v", err)
+		}
+	}
+	s.swapOverride(nil)
+	return nil
+}
+
+// OverrideAt returns the override that applies at t, if any.
+func (s *Schedule) OverrideAt(t time.Time) (Override, bool) {
+	o := s.effective().override
+	if !o.activeAt(t) {
+		return Override{}, false
+	}
+	return *o, true
+}
+
+// ActiveOverride returns the override that applies now, if any.
+func (s *Schedule) ActiveOverride() (Override, bool) {
+	return s.OverrideAt(s.now())
+}
+
+// SetOverrideStore persists overrides in store and loads the override
+// stored there, if it has not expired yet.
+func (s *Schedule) SetOverrideStore(store OverrideStore) error {
+	s.mu.Lock()
+	s.store = store
+	s.mu.Unlock()
+	_, err := s.SyncOverride()
+	return err
+}
+
+func (s *Schedule) overrideStore() OverrideStore {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.store
+}
+
+// SyncOverride loads the override from the store, so overrides set by
+// another process take effect, and removes it from the store once it has
+// expired. It reports whether the override in effect changed.
+func (s *Schedule) SyncOverride() (bool, error) {
+	store := s.overrideStore()
+	if store == nil {
+		return false, nil
+	}
+
+	stored, err := store.GetOverride()
+	if err != nil {
+		return false, err
+	}
+
+	var o *Override
+	if stored != nil {
+		o = &Override{Mode: OverrideMode(stored.Mode), From: stored.From, Until: stored.Until}
+		if o.Mode != ForceActive && o.Mode != ForceAway {
+			return false, fmt.Errorf("unknown override mode %q", stored.Mode)
+		}
+		if !s.now().Before(o.Until) {
+			if err := store.ClearOverride(); err != nil {
+				return false, fmt.Errorf("failed to clear expired override: %v", err)
+			}
+			o = nil
+		}
+	}
+
+	current := s.effective().override
+	if overridesEqual(current, o) {
+		return false, nil
+	}
+	s.swapOverride(o)
+	return true, nil
+}
+
+func overridesEqual(a, b *Override) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Mode == b.Mode && a.From.Equal(b.From) && a.Until.Equal(b.Until)
+}
+
+func (s *Schedule) swapOverride(o *Override) {
+	s.mu.Lock()
+	s.override = o
+	s.notifyLocked()
+	s.mu.Unlock()
+}
+
+// WatchOverrides polls the override store every interval, so overrides set
+// from the command line take effect, and clears expired overrides. It
+// returns when ctx is cancelled.
+func (s *Schedule) WatchOverrides(ctx context.Context, interval time.Duration) {
+	ticker := s.getClock().NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			before := s.effective().override
+			changed, err := s.SyncOverride()
+			if err != nil {
+				logger.Error("Failed to read schedule override: %v", err)
+				continue
+			}
+			if !changed {
+				continue
+			}
+			if o, ok := s.ActiveOverride(); ok {
+				logger.Info("Schedule override set: %s", o.describe(s.Location()))
+			} else if before != nil && !s.now().Before(before.Until) {
+				logger.Info("Schedule override expired")
+			} else {
+				logger.Info("Schedule override cleared")
+			}
+		}
+	}
+}
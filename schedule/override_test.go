@@ -0,0 +1,130 @@
+package schedule
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lucy/slack-always-active/cache"
+	"github.com/lucy/slack-always-active/clock"
+)
+
+// memStore keeps the override in memory.
+type memStore struct {
+	mu       sync.Mutex
+	override *cache.Override
+}
+
+func (m *memStore) GetOverride() (*cache.Override, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.override == nil {
+		return nil, nil
+	}
+	o := *m.override
+	return &o, nil
+}
+
+func (m *memStore) SetOverride(o cache.Override) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.override = &o
+	return nil
+}
+
+func (m *memStore) ClearOverride() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.override = nil
+	return nil
+}
+
+func TestOverride(t *testing.T) {
+	loc := mustLoad(t, "Europe/Berlin")
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 6, day, hour, minute, 0, 0, loc)
+	}
+	s := newTestSchedule(t, "mon-fri 09:00-18:00", loc)
+	clk := clock.NewFake(at(3, 17, 0))
+	s.SetClock(clk)
+
+	// Working late on Monday
+	if err := s.ForceActiveUntil(at(3, 20, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsWorkingTimeAt(at(3, 19, 0)) {
+		t.Error("19:00 should be working time during the override")
+	}
+	if got := s.NextTransitionAt(at(3, 17, 0)); !got.Equal(at(3, 20, 0)) {
+		t.Errorf("NextTransitionAt = %v, want the end of the override", got)
+	}
+	if got := s.GetNextWorkingTimeAt(at(3, 20, 0)); !got.Equal(at(4, 9, 0)) {
+		t.Errorf("GetNextWorkingTimeAt after the override = %v, want Tuesday 09:00", got)
+	}
+
+	// Leaving early until Tuesday noon hides the Tuesday morning
+	if err := s.ForceAwayUntil(at(4, 12, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if s.IsWorkingTimeAt(at(3, 17, 30)) || s.IsWorkingTimeAt(at(4, 10, 0)) {
+		t.Error("the away override should end working time until Tuesday noon")
+	}
+	if got := s.GetNextWorkingTimeAt(at(3, 17, 30)); !got.Equal(at(4, 12, 0)) {
+		t.Errorf("GetNextWorkingTimeAt = %v, want Tuesday 12:00", got)
+	}
+	if got := s.NextTransitionAt(at(4, 12, 0)); !got.Equal(at(4, 18, 0)) {
+		t.Errorf("NextTransitionAt after the override = %v, want Tuesday 18:00", got)
+	}
+
+	if err := s.ClearOverride(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsWorkingTimeAt(at(3, 17, 30)) {
+		t.Error("clearing the override should restore the schedule")
+	}
+
+	if err := s.ForceActiveUntil(at(3, 16, 0)); err == nil {
+		t.Error("an override ending in the past should be rejected")
+	}
+}
+
+func TestOverridePersistence(t *testing.T) {
+	loc := time.UTC
+	at := func(hour int) time.Time {
+		return time.Date(2024, 6, 3, hour, 0, 0, 0, loc)
+	}
+	store := &memStore{}
+
+	s := newTestSchedule(t, "mon-fri 09:00-18:00", loc)
+	clk := clock.NewFake(at(19))
+	s.SetClock(clk)
+	if err := s.SetOverrideStore(store); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ForceActiveUntil(at(21)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted process picks up the stored override
+	restarted := newTestSchedule(t, "mon-fri 09:00-18:00", loc)
+	restarted.SetClock(clk)
+	if err := restarted.SetOverrideStore(store); err != nil {
+		t.Fatal(err)
+	}
+	if o, ok := restarted.ActiveOverride(); !ok || o.Mode != ForceActive || !o.Until.Equal(at(21)) {
+		t.Errorf("ActiveOverride after restart = %v, %v", o, ok)
+	}
+
+	// Once expired it is removed from the store
+	clk.Set(at(22))
+	changed, err := restarted.SyncOverride()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("SyncOverride should report the expired override")
+	}
+	if store.override != nil {
+		t.Error("expired override was not removed from the store")
+	}
+}
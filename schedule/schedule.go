@@ -5,35 +5,101 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/lucy/slack-always-active/clock"
 )
 
+// maxSearch bounds the number of windows GetNextWorkingTimeAt inspects, so
+// a calendar that blocks every window cannot loop forever.
+const maxSearch = 1000
+
+// ruleSet decides which times fall inside working windows, before holidays
+// and vacations are applied.
+type ruleSet interface {
+	inWindow(t time.Time) bool
+	nextWindowStart(t time.Time) time.Time
+	windowEnd(t time.Time) time.Time
+	explain(t time.Time) string
+}
+
 type Schedule struct {
-	workDays []time.Weekday
-	start    time.Time
-	end      time.Time
-	offset   int
+	mu       sync.RWMutex
+	plan     *plan
+	override *Override
+	store    OverrideStore
+	path     string
+	changed  chan struct{}
+	clock    clock.Clock
 }
 
-func parseTime(timeStr string) (time.Time, error) {
-	parts := strings.Split(timeStr, ":")
-	if len(parts) != 2 {
-		return time.Time{}, fmt.Errorf("invalid time format: %s", timeStr)
+// plan is a validated schedule configuration. It is never modified once
+// built; reloading the configuration swaps in a new plan as a whole.
+type plan struct {
+	rules     ruleSet
+	loc       *time.Location
+	calendar  *Calendar
+	calendars []string
+	holidays  *holidays
+	jitter    *jitter
+	limits    limits
+}
+
+func NewSchedule() (*Schedule, error) {
+	// Read the schedule from a config file if one is given, otherwise from
+	// environment variables
+	path := os.Getenv("SCHEDULE_FILE")
+	config, err := configFromEnv()
+	if path != "" {
+		config, err = LoadConfig(path)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	hour, err := strconv.Atoi(parts[0])
+	s, err := NewScheduleFromConfig(config)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("invalid hour: %s", parts[0])
+		return nil, err
 	}
+	s.path = path
+	return s, nil
+}
 
-	minute, err := strconv.Atoi(parts[1])
+// NewScheduleFromConfig builds a schedule from the given settings.
+func NewScheduleFromConfig(config *Config) (*Schedule, error) {
+	p, err := config.build()
 	if err != nil {
-		return time.Time{}, fmt.Errorf("invalid minute: %s", parts[1])
+		return nil, err
 	}
 
-	// Create time for today with the specified hour and minute
-	now := time.Now()
-	return time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, time.UTC), nil
+	return &Schedule{
+		plan:  p,
+		clock: clock.Real,
+	}, nil
+}
+
+// SetClock replaces the clock the schedule reads the current time from.
+func (s *Schedule) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+func (s *Schedule) getClock() clock.Clock {
+	if s.clock == nil {
+		return clock.Real
+	}
+	return s.clock
+}
+
+func (s *Schedule) now() time.Time {
+	return s.getClock().Now()
+}
+
+// current returns the plan in effect.
+func (s *Schedule) current() *plan {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.plan
 }
 
 func parseWorkDays(daysStr string) ([]time.Weekday, error) {
@@ -48,34 +114,11 @@ func parseWorkDays(daysStr string) ([]time.Weekday, error) {
 		}, nil
 	}
 
-	days := strings.Split(daysStr, ",")
-	workDays := make([]time.Weekday, 0, len(days))
-
-	for _, day := range days {
-		day = strings.TrimSpace(strings.ToLower(day))
-		switch day {
-		case "monday":
-			workDays = append(workDays, time.Monday)
-		case "tuesday":
-			workDays = append(workDays, time.Tuesday)
-		case "wednesday":
-			workDays = append(workDays, time.Wednesday)
-		case "thursday":
-			workDays = append(workDays, time.Thursday)
-		case "friday":
-			workDays = append(workDays, time.Friday)
-		case "saturday":
-			workDays = append(workDays, time.Saturday)
-		case "sunday":
-			workDays = append(workDays, time.Sunday)
-		default:
-			return nil, fmt.Errorf("invalid day: %s", day)
-		}
-	}
-
-	return workDays, nil
+	return parseDays(daysStr)
 }
 
+// parseOffset parses a GMT offset such as "+2", "GMT-5" or "+5:30" and
+// returns it in seconds east of UTC.
 func parseOffset(offsetStr string) (int, error) {
 	if offsetStr == "" {
 		return 0, nil // Default to UTC
@@ -85,119 +128,240 @@ func parseOffset(offsetStr string) (int, error) {
 	offsetStr = strings.TrimPrefix(offsetStr, "GMT")
 	offsetStr = strings.TrimPrefix(offsetStr, "gmt")
 
+	hourStr, minuteStr, hasMinutes := strings.Cut(offsetStr, ":")
+
 	// Parse the offset
-	offset, err := strconv.Atoi(offsetStr)
-	if err != nil {
+	hours, err := strconv.Atoi(hourStr)
+	if err != nil || hours < -14 || hours > 14 {
 		return 0, fmt.Errorf("invalid GMT offset: %s", offsetStr)
 	}
 
-	return offset, nil
+	minutes := 0
+	if hasMinutes {
+		minutes, err = strconv.Atoi(minuteStr)
+		if err != nil || minutes < 0 || minutes > 59 {
+			return 0, fmt.Errorf("invalid GMT offset: %s", offsetStr)
+		}
+	}
+
+	seconds := hours*3600 + minutes*60
+	if strings.HasPrefix(hourStr, "-") {
+		seconds = hours*3600 - minutes*60
+	}
+	return seconds, nil
 }
 
-func NewSchedule() (*Schedule, error) {
-	// Get work days from environment
-	workDays, err := parseWorkDays(os.Getenv("WORK_DAYS"))
+// parseLocation resolves the schedule time zone. An IANA zone name such as
+// "Europe/Berlin" takes precedence; otherwise the fixed GMT offset is used.
+func parseLocation(zoneStr, offsetStr string) (*time.Location, error) {
+	if zoneStr != "" {
+		loc, err := time.LoadLocation(zoneStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone: %s", zoneStr)
+		}
+		return loc, nil
+	}
+
+	offset, err := parseOffset(offsetStr)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing work days: %v", err)
+		return nil, err
+	}
+	if offset == 0 {
+		return time.UTC, nil
+	}
+
+	sign, abs := "+", offset
+	if offset < 0 {
+		sign, abs = "-", -offset
+	}
+	name := fmt.Sprintf("GMT%s%d", sign, abs/3600)
+	if abs%3600 != 0 {
+		name = fmt.Sprintf("%s:%02d", name, abs%3600/60)
+	}
+	return time.FixedZone(name, offset), nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(listStr string) []string {
+	var items []string
+	for _, item := range strings.Split(listStr, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseWorkHours builds the weekly windows either from a WORK_HOURS spec or,
+// when that is empty, from the WORK_DAYS, WORK_START and WORK_END trio.
+func parseWorkHours(hoursStr, daysStr, startStr, endStr string) ([7][]window, error) {
+	if hoursStr != "" {
+		days, err := parseWeek(hoursStr)
+		if err != nil {
+			return days, fmt.Errorf("error parsing work hours: %v", err)
+		}
+		return days, nil
 	}
 
-	// Get work hours from environment
-	startTime, err := parseTime(os.Getenv("WORK_START"))
+	var days [7][]window
+
+	workDays, err := parseWorkDays(daysStr)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing start time: %v", err)
+		return days, fmt.Errorf("error parsing work days: %v", err)
 	}
 
-	endTime, err := parseTime(os.Getenv("WORK_END"))
+	startTime, err := parseTimeOfDay(startStr)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing end time: %v", err)
+		return days, fmt.Errorf("error parsing start time: %v", err)
 	}
 
-	// Get GMT offset from environment
-	offset, err := parseOffset(os.Getenv("GMT_OFFSET"))
+	endTime, err := parseTimeOfDay(endStr)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing GMT offset: %v", err)
+		return days, fmt.Errorf("error parsing end time: %v", err)
 	}
 
-	return &Schedule{
-		workDays: workDays,
-		start:    startTime,
-		end:      endTime,
-		offset:   offset,
-	}, nil
+	w, err := parseWindow(startTime.String() + "-" + endTime.String())
+	if err != nil {
+		return days, fmt.Errorf("error parsing work hours: %v", err)
+	}
+
+	for _, day := range workDays {
+		days[day] = []window{w}
+	}
+	return days, nil
 }
 
 func (s *Schedule) IsWorkingTime() bool {
-	// Get current time in UTC
-	now := time.Now().UTC()
-
-	// Adjust current time by the GMT offset
-	now = now.Add(time.Duration(s.offset) * time.Hour)
-
-	// Check if current day is a work day
-	isWorkDay := false
-	for _, day := range s.workDays {
-		if now.Weekday() == day {
-			isWorkDay = true
-			break
-		}
+	return s.IsWorkingTimeAt(s.now())
+}
+
+// IsWorkingTimeAt reports whether t falls within working hours and outside
+// any holiday or vacation, unless an override applies at t.
+func (s *Schedule) IsWorkingTimeAt(t time.Time) bool {
+	return s.effective().workingAt(t)
+}
+
+func (s *Schedule) GetNextWorkingTime() time.Time {
+	return s.GetNextWorkingTimeAt(s.now())
+}
+
+// GetNextWorkingTimeAt returns t if it falls within working hours, otherwise
+// the start of the next working period, in the schedule location. Holidays
+// and vacations are skipped. It returns the zero time if no working period
+// can be found.
+func (s *Schedule) GetNextWorkingTimeAt(t time.Time) time.Time {
+	return s.effective().nextWorkingAt(t)
+}
+
+// NextTransition returns the next time the result of IsWorkingTime changes.
+func (s *Schedule) NextTransition() time.Time {
+	return s.NextTransitionAt(s.now())
+}
+
+// NextTransitionAt returns the first time after t at which the result of
+// IsWorkingTimeAt changes, in the schedule location, or the zero time if it
+// never does.
+func (s *Schedule) NextTransitionAt(t time.Time) time.Time {
+	return s.effective().nextTransitionAt(t)
+}
+
+// Location returns the time zone the schedule is evaluated in.
+func (s *Schedule) Location() *time.Location {
+	return s.current().loc
+}
+
+func (p *plan) isWorkingTimeAt(t time.Time) bool {
+	if p.jitter != nil {
+		return p.jitter.workingAt(p, t)
 	}
+	return p.rawWorkingAt(t)
+}
 
-	if !isWorkDay {
-		return false
+func (p *plan) nextWorkingTimeAt(t time.Time) time.Time {
+	if p.jitter != nil {
+		return p.jitter.nextWorkingAt(p, t)
 	}
+	return p.rawNextWorkingAt(t)
+}
 
-	// Create current time with only hour and minute for comparison
-	currentTime := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), 0, 0, time.UTC)
-	startTime := time.Date(now.Year(), now.Month(), now.Day(), s.start.Hour(), s.start.Minute(), 0, 0, time.UTC)
-	endTime := time.Date(now.Year(), now.Month(), now.Day(), s.end.Hour(), s.end.Minute(), 0, 0, time.UTC)
+func (p *plan) nextTransitionAt(t time.Time) time.Time {
+	if p.jitter != nil {
+		return p.jitter.nextTransitionAt(p, t)
+	}
+	return p.rawNextTransitionAt(t)
+}
 
-	// Check if current time is within work hours
-	return currentTime.After(startTime) && currentTime.Before(endTime)
+// rawWorkingAt is isWorkingTimeAt before jitter and breaks are applied.
+func (p *plan) rawWorkingAt(t time.Time) bool {
+	if !p.rules.inWindow(t) {
+		return false
+	}
+	_, blocked := p.blockedUntil(t)
+	return !blocked
 }
 
-func (s *Schedule) GetNextWorkingTime() time.Time {
-	now := time.Now().UTC()
-	now = now.Add(time.Duration(s.offset) * time.Hour)
-
-	// Create current time with only hour and minute for comparison
-	currentTime := time.Date(2000, 1, 1, now.Hour(), now.Minute(), 0, 0, time.UTC)
-	startTime := time.Date(2000, 1, 1, s.start.Hour(), s.start.Minute(), 0, 0, time.UTC)
-	endTime := time.Date(2000, 1, 1, s.end.Hour(), s.end.Minute(), 0, 0, time.UTC)
-
-	// If we're before start time today, return start time today
-	if currentTime.Before(startTime) {
-		nextTime := time.Date(now.Year(), now.Month(), now.Day(), s.start.Hour(), s.start.Minute(), 0, 0, time.UTC)
-		return nextTime.Add(-time.Duration(s.offset) * time.Hour)
-	}
-
-	// If we're after end time today, find next work day
-	if currentTime.After(endTime) {
-		// Start from tomorrow
-		nextDay := now.Add(24 * time.Hour)
-		for {
-			// Check if this is a work day
-			isWorkDay := false
-			for _, day := range s.workDays {
-				if nextDay.Weekday() == day {
-					isWorkDay = true
-					break
-				}
-			}
+// rawNextWorkingAt is nextWorkingTimeAt before jitter and breaks are applied.
+func (p *plan) rawNextWorkingAt(t time.Time) time.Time {
+	now := t.In(p.loc)
 
-			if isWorkDay {
-				// Return start time on this day
-				nextTime := time.Date(nextDay.Year(), nextDay.Month(), nextDay.Day(), s.start.Hour(), s.start.Minute(), 0, 0, time.UTC)
-				return nextTime.Add(-time.Duration(s.offset) * time.Hour)
+	for i := 0; i < maxSearch; i++ {
+		if !p.rules.inWindow(now) {
+			now = p.rules.nextWindowStart(now)
+			if now.IsZero() {
+				return now
 			}
+		}
 
-			nextDay = nextDay.Add(24 * time.Hour)
+		// If a holiday or calendar event covers this time, resume once it
+		// is over
+		until, blocked := p.blockedUntil(now)
+		if !blocked {
+			return now
 		}
+		now = until.In(p.loc)
 	}
 
-	// If we're within working hours, return current time
-	return now.Add(-time.Duration(s.offset) * time.Hour)
+	return time.Time{}
 }
 
-func (s *Schedule) GetOffset() int {
-	return s.offset
+// rawNextTransitionAt is nextTransitionAt before jitter and breaks are
+// applied.
+func (p *plan) rawNextTransitionAt(t time.Time) time.Time {
+	if !p.rawWorkingAt(t) {
+		return p.rawNextWorkingAt(t)
+	}
+
+	// Working time ends with the window or when a holiday or calendar event
+	// begins
+	end := p.rules.windowEnd(t)
+	if block := p.nextBlockStart(t); !block.IsZero() && (end.IsZero() || block.Before(end)) {
+		end = block
+	}
+	if end.IsZero() {
+		return end
+	}
+	return end.In(p.loc)
+}
+
+// blockedUntil reports whether a holiday or calendar event covers t and,
+// if so, until when.
+func (p *plan) blockedUntil(t time.Time) (time.Time, bool) {
+	until, blocked := p.calendar.blockedUntil(t)
+	if holidayUntil, ok := p.holidays.blockedUntil(t); ok {
+		if holidayUntil.After(until) {
+			until = holidayUntil
+		}
+		blocked = true
+	}
+	return until, blocked
+}
+
+// nextBlockStart returns the earliest start of a holiday or calendar event
+// after t, or the zero time if there is none.
+func (p *plan) nextBlockStart(t time.Time) time.Time {
+	earliest := p.calendar.nextBlockStart(t)
+	if start := p.holidays.nextBlockStart(t); !start.IsZero() && (earliest.IsZero() || start.Before(earliest)) {
+		earliest = start
+	}
+	return earliest
 }
@@ -0,0 +1,382 @@
+package schedule
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s not available: %v", name, err)
+	}
+	return loc
+}
+
+func newTestSchedule(t *testing.T, week string, loc *time.Location) *Schedule {
+	t.Helper()
+	days, err := parseWeek(week)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Schedule{plan: &plan{rules: &weeklyRules{days: days, loc: loc}, loc: loc}}
+}
+
+func mustSchedule(t *testing.T, config *Config) *Schedule {
+	t.Helper()
+	s, err := NewScheduleFromConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestParseLocation(t *testing.T) {
+	tests := []struct {
+		zone, offset string
+		want         int
+	}{
+		{"", "", 0},
+		{"", "+2", 2 * 3600},
+		{"", "GMT-5", -5 * 3600},
+		{"", "+5:30", 5*3600 + 30*60},
+		{"", "GMT+5:45", 5*3600 + 45*60},
+		{"", "-3:30", -(3*3600 + 30*60)},
+		{"Asia/Kolkata", "+2", 5*3600 + 30*60},
+	}
+	for _, tt := range tests {
+		loc, err := parseLocation(tt.zone, tt.offset)
+		if err != nil {
+			t.Fatalf("parseLocation(%q, %q): %v", tt.zone, tt.offset, err)
+		}
+		_, got := time.Date(2024, 6, 1, 12, 0, 0, 0, loc).Zone()
+		if got != tt.want {
+			t.Errorf("parseLocation(%q, %q) offset = %d, want %d", tt.zone, tt.offset, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"abc", "+2:75", "+15"} {
+		if _, err := parseLocation("", bad); err == nil {
+			t.Errorf("parseLocation(%q) succeeded, want error", bad)
+		}
+	}
+	if _, err := parseLocation("Not/AZone", ""); err == nil {
+		t.Error("parseLocation with unknown zone succeeded, want error")
+	}
+}
+
+func TestIsWorkingTimeAtDST(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	newYork := mustLoad(t, "America/New_York")
+
+	const weekdays = "mon-fri 09:00-18:00"
+	// One window starts inside the hour skipped on 2024-03-31 and one ends
+	// inside the hour repeated on 2024-10-27.
+	const springSunday = "sun 02:30-04:00"
+	const fallSunday = "sun 01:00-02:30"
+
+	tests := []struct {
+		name string
+		week string
+		loc  *time.Location
+		at   time.Time
+		want bool
+	}{
+		// Europe/Berlin springs forward on 2024-03-31 and falls back on
+		// 2024-10-27; both are Sundays, so check the following Mondays too.
+		{"berlin before DST start", weekdays, berlin, time.Date(2024, 3, 29, 8, 0, 0, 0, time.UTC), true},
+		{"berlin after DST start", weekdays, berlin, time.Date(2024, 4, 1, 7, 0, 0, 0, time.UTC), true},
+		{"berlin after DST start early", weekdays, berlin, time.Date(2024, 4, 1, 6, 59, 0, 0, time.UTC), false},
+		{"berlin after DST start end", weekdays, berlin, time.Date(2024, 4, 1, 16, 0, 0, 0, time.UTC), false},
+		{"berlin after DST end", weekdays, berlin, time.Date(2024, 10, 28, 8, 0, 0, 0, time.UTC), true},
+		{"berlin after DST end early", weekdays, berlin, time.Date(2024, 10, 28, 7, 59, 0, 0, time.UTC), false},
+		{"berlin after DST end late", weekdays, berlin, time.Date(2024, 10, 28, 16, 30, 0, 0, time.UTC), true},
+		// The transition Sundays themselves: 02:30 CET does not exist, so
+		// the window opens at 03:30 CEST; 02:30 happens twice, and the
+		// window runs until the second one, 02:30 CET.
+		{"berlin DST start sunday before", springSunday, berlin, time.Date(2024, 3, 31, 1, 29, 0, 0, time.UTC), false},
+		{"berlin DST start sunday start", springSunday, berlin, time.Date(2024, 3, 31, 1, 30, 0, 0, time.UTC), true},
+		{"berlin DST start sunday last minute", springSunday, berlin, time.Date(2024, 3, 31, 1, 59, 0, 0, time.UTC), true},
+		{"berlin DST start sunday end", springSunday, berlin, time.Date(2024, 3, 31, 2, 0, 0, 0, time.UTC), false},
+		{"berlin DST end sunday before", fallSunday, berlin, time.Date(2024, 10, 26, 22, 59, 0, 0, time.UTC), false},
+		{"berlin DST end sunday start", fallSunday, berlin, time.Date(2024, 10, 26, 23, 0, 0, 0, time.UTC), true},
+		{"berlin DST end sunday first 02:30", fallSunday, berlin, time.Date(2024, 10, 27, 0, 30, 0, 0, time.UTC), true},
+		{"berlin DST end sunday last minute", fallSunday, berlin, time.Date(2024, 10, 27, 1, 29, 0, 0, time.UTC), true},
+		{"berlin DST end sunday end", fallSunday, berlin, time.Date(2024, 10, 27, 1, 30, 0, 0, time.UTC), false},
+		// America/New_York springs forward on 2024-03-10
+		{"new york before DST start", weekdays, newYork, time.Date(2024, 3, 8, 14, 0, 0, 0, time.UTC), true},
+		{"new york after DST start", weekdays, newYork, time.Date(2024, 3, 11, 13, 0, 0, 0, time.UTC), true},
+		{"new york after DST start early", weekdays, newYork, time.Date(2024, 3, 11, 12, 59, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		s := newTestSchedule(t, tt.week, tt.loc)
+		if got := s.IsWorkingTimeAt(tt.at); got != tt.want {
+			t.Errorf("%s: IsWorkingTimeAt(%s) = %v, want %v", tt.name, tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestIsWorkingTimeAtFractionalOffset(t *testing.T) {
+	for _, tt := range []struct {
+		zone  string
+		start time.Time
+	}{
+		{"Asia/Kolkata", time.Date(2024, 6, 3, 3, 30, 0, 0, time.UTC)},
+		{"Asia/Kathmandu", time.Date(2024, 6, 3, 3, 15, 0, 0, time.UTC)},
+	} {
+		s := newTestSchedule(t, "mon-fri 09:00-18:00", mustLoad(t, tt.zone))
+		if s.IsWorkingTimeAt(tt.start.Add(-time.Minute)) {
+			t.Errorf("%s: working one minute before start", tt.zone)
+		}
+		if !s.IsWorkingTimeAt(tt.start) {
+			t.Errorf("%s: not working at start", tt.zone)
+		}
+	}
+}
+
+func TestGetNextWorkingTimeAtDST(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	const weekdays = "mon-fri 09:00-18:00"
+	const springSunday = "sun 02:30-04:00"
+	const fallSunday = "sun 01:00-02:30"
+
+	tests := []struct {
+		name string
+		week string
+		at   time.Time
+		want time.Time
+	}{
+		{"friday evening before DST start", weekdays, time.Date(2024, 3, 29, 18, 0, 0, 0, time.UTC), time.Date(2024, 4, 1, 7, 0, 0, 0, time.UTC)},
+		{"friday evening before DST end", weekdays, time.Date(2024, 10, 25, 17, 0, 0, 0, time.UTC), time.Date(2024, 10, 28, 8, 0, 0, 0, time.UTC)},
+		{"same day before start", weekdays, time.Date(2024, 10, 28, 6, 0, 0, 0, time.UTC), time.Date(2024, 10, 28, 8, 0, 0, 0, time.UTC)},
+		{"within working hours", weekdays, time.Date(2024, 10, 28, 9, 0, 0, 0, time.UTC), time.Date(2024, 10, 28, 9, 0, 0, 0, time.UTC)},
+		// A window starting at 02:30 on the day 02:00 jumps to 03:00 opens
+		// at 03:30 CEST
+		{"saturday evening before DST start", springSunday, time.Date(2024, 3, 30, 20, 0, 0, 0, time.UTC), time.Date(2024, 3, 31, 1, 30, 0, 0, time.UTC)},
+		{"right after the skipped hour", springSunday, time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC), time.Date(2024, 3, 31, 1, 30, 0, 0, time.UTC)},
+		{"after the window on DST start", springSunday, time.Date(2024, 3, 31, 2, 0, 0, 0, time.UTC), time.Date(2024, 4, 7, 0, 30, 0, 0, time.UTC)},
+		// A window ending at 02:30 on the day 03:00 falls back to 02:00 runs
+		// through both 02:30s
+		{"saturday evening before DST end", fallSunday, time.Date(2024, 10, 26, 20, 0, 0, 0, time.UTC), time.Date(2024, 10, 26, 23, 0, 0, 0, time.UTC)},
+		{"inside the repeated hour", fallSunday, time.Date(2024, 10, 27, 1, 15, 0, 0, time.UTC), time.Date(2024, 10, 27, 1, 15, 0, 0, time.UTC)},
+		{"after the window on DST end", fallSunday, time.Date(2024, 10, 27, 1, 30, 0, 0, time.UTC), time.Date(2024, 11, 3, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s := newTestSchedule(t, tt.week, berlin)
+		got := s.GetNextWorkingTimeAt(tt.at)
+		if !got.Equal(tt.want) {
+			t.Errorf("%s: GetNextWorkingTimeAt(%s) = %s, want %s", tt.name, tt.at, got.UTC(), tt.want)
+		}
+		if got.Location() != berlin {
+			t.Errorf("%s: GetNextWorkingTimeAt returned location %s, want %s", tt.name, got.Location(), berlin)
+		}
+	}
+}
+
+func TestParseWeek(t *testing.T) {
+	days, err := parseWeek("mon-thu 09:00-12:00, 13:00-18:00; fri 09:00-14:00; sat-sun 10:00-11:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[time.Weekday]string{
+		time.Sunday:    "[10:00-11:00]",
+		time.Monday:    "[09:00-12:00 13:00-18:00]",
+		time.Tuesday:   "[09:00-12:00 13:00-18:00]",
+		time.Wednesday: "[09:00-12:00 13:00-18:00]",
+		time.Thursday:  "[09:00-12:00 13:00-18:00]",
+		time.Friday:    "[09:00-14:00]",
+		time.Saturday:  "[10:00-11:00]",
+	}
+	for day, w := range want {
+		if got := fmt.Sprint(days[day]); got != w {
+			t.Errorf("%s windows = %s, want %s", day, got, w)
+		}
+	}
+
+	for _, bad := range []string{
+		"mon",
+		"xyz 09:00-10:00",
+		"mon 09:00",
+		"mon 10:00-09:00-11:00",
+		"mon 09:00-12:00,11:00-13:00",
+		"mon 09:00-10:00; mon 09:30-11:00",
+		"mon 25:00-26:00",
+		"mon09:00-10:00",
+	} {
+		if _, err := parseWeek(bad); err == nil {
+			t.Errorf("parseWeek(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestParseWeekSpacedDays(t *testing.T) {
+	for _, week := range []string{"mon, tue 09:00-17:00", "mon ,tue  09:00-17:00", "Mon , Tue 09:00-17:00"} {
+		days, err := parseWeek(week)
+		if err != nil {
+			t.Errorf("parseWeek(%q): %v", week, err)
+			continue
+		}
+		for day := range days {
+			want := "[]"
+			if time.Weekday(day) == time.Monday || time.Weekday(day) == time.Tuesday {
+				want = "[09:00-17:00]"
+			}
+			if got := fmt.Sprint(days[day]); got != want {
+				t.Errorf("parseWeek(%q) %s windows = %s, want %s", week, time.Weekday(day), got, want)
+			}
+		}
+	}
+}
+
+func TestParseWorkDays(t *testing.T) {
+	tests := []struct {
+		days string
+		want string
+	}{
+		{"", "[Monday Tuesday Wednesday Thursday Friday]"},
+		{"Monday,Tuesday", "[Monday Tuesday]"},
+		{"mon, tue", "[Monday Tuesday]"},
+		{"Sat,sunday", "[Saturday Sunday]"},
+		{"mon-wed", "[Monday Tuesday Wednesday]"},
+	}
+	for _, tt := range tests {
+		days, err := parseWorkDays(tt.days)
+		if err != nil {
+			t.Errorf("parseWorkDays(%q): %v", tt.days, err)
+			continue
+		}
+		if got := fmt.Sprint(days); got != tt.want {
+			t.Errorf("parseWorkDays(%q) = %s, want %s", tt.days, got, tt.want)
+		}
+	}
+	if _, err := parseWorkDays("mon,funday"); err == nil {
+		t.Error("parseWorkDays with unknown day succeeded, want error")
+	}
+}
+
+func TestSplitShift(t *testing.T) {
+	s := newTestSchedule(t, "mon-thu 09:00-12:00,13:00-18:00; fri 09:00-14:00", time.UTC)
+
+	// Monday 2024-06-03
+	tests := []struct {
+		at       time.Time
+		working  bool
+		nextWork time.Time
+	}{
+		{time.Date(2024, 6, 3, 11, 59, 0, 0, time.UTC), true, time.Date(2024, 6, 3, 11, 59, 0, 0, time.UTC)},
+		{time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC), false, time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC), true, time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 6, 7, 14, 0, 0, 0, time.UTC), false, time.Date(2024, 6, 10, 9, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 6, 7, 13, 59, 0, 0, time.UTC), true, time.Date(2024, 6, 7, 13, 59, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := s.IsWorkingTimeAt(tt.at); got != tt.working {
+			t.Errorf("IsWorkingTimeAt(%s) = %v, want %v", tt.at, got, tt.working)
+		}
+		if got := s.GetNextWorkingTimeAt(tt.at); !got.Equal(tt.nextWork) {
+			t.Errorf("GetNextWorkingTimeAt(%s) = %s, want %s", tt.at, got, tt.nextWork)
+		}
+	}
+}
+
+func TestOvernightShift(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+
+	tests := []struct {
+		name     string
+		week     string
+		loc      *time.Location
+		at       time.Time
+		working  bool
+		nextWork time.Time
+	}{
+		// 2024-06-07 is a Friday
+		{"before friday shift", "mon-fri 22:00-06:00", time.UTC, time.Date(2024, 6, 7, 21, 59, 0, 0, time.UTC), false, time.Date(2024, 6, 7, 22, 0, 0, 0, time.UTC)},
+		{"friday shift start", "mon-fri 22:00-06:00", time.UTC, time.Date(2024, 6, 7, 22, 0, 0, 0, time.UTC), true, time.Date(2024, 6, 7, 22, 0, 0, 0, time.UTC)},
+		{"friday shift at midnight", "mon-fri 22:00-06:00", time.UTC, time.Date(2024, 6, 8, 0, 0, 0, 0, time.UTC), true, time.Date(2024, 6, 8, 0, 0, 0, 0, time.UTC)},
+		{"friday shift last minute", "mon-fri 22:00-06:00", time.UTC, time.Date(2024, 6, 8, 5, 59, 0, 0, time.UTC), true, time.Date(2024, 6, 8, 5, 59, 0, 0, time.UTC)},
+		{"friday shift end", "mon-fri 22:00-06:00", time.UTC, time.Date(2024, 6, 8, 6, 0, 0, 0, time.UTC), false, time.Date(2024, 6, 10, 22, 0, 0, 0, time.UTC)},
+		{"saturday night", "mon-fri 22:00-06:00", time.UTC, time.Date(2024, 6, 8, 23, 0, 0, 0, time.UTC), false, time.Date(2024, 6, 10, 22, 0, 0, 0, time.UTC)},
+		{"sunday night into monday", "mon-fri 22:00-06:00", time.UTC, time.Date(2024, 6, 10, 1, 0, 0, 0, time.UTC), false, time.Date(2024, 6, 10, 22, 0, 0, 0, time.UTC)},
+		{"monday shift into tuesday", "mon-fri 22:00-06:00", time.UTC, time.Date(2024, 6, 11, 1, 0, 0, 0, time.UTC), true, time.Date(2024, 6, 11, 1, 0, 0, 0, time.UTC)},
+		{"shift ending at midnight", "fri 18:00-00:00", time.UTC, time.Date(2024, 6, 7, 23, 59, 0, 0, time.UTC), true, time.Date(2024, 6, 7, 23, 59, 0, 0, time.UTC)},
+		{"after shift ending at midnight", "fri 18:00-00:00", time.UTC, time.Date(2024, 6, 8, 0, 0, 0, 0, time.UTC), false, time.Date(2024, 6, 14, 18, 0, 0, 0, time.UTC)},
+		{"day and night windows", "mon 09:00-12:00,22:00-02:00", time.UTC, time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC), false, time.Date(2024, 6, 10, 22, 0, 0, 0, time.UTC)},
+		{"night window into next day", "mon 09:00-12:00,22:00-02:00", time.UTC, time.Date(2024, 6, 11, 1, 59, 0, 0, time.UTC), true, time.Date(2024, 6, 11, 1, 59, 0, 0, time.UTC)},
+		// Europe/Berlin springs forward at 02:00 on Sunday 2024-03-31, so the
+		// Saturday night shift is one hour shorter in real time.
+		{"night shift over DST start", "sat 22:00-06:00", berlin, time.Date(2024, 3, 31, 3, 59, 0, 0, time.UTC), true, time.Date(2024, 3, 31, 3, 59, 0, 0, time.UTC)},
+		{"night shift over DST start end", "sat 22:00-06:00", berlin, time.Date(2024, 3, 31, 4, 0, 0, 0, time.UTC), false, time.Date(2024, 4, 6, 20, 0, 0, 0, time.UTC)},
+		// Europe/Berlin falls back at 03:00 on Sunday 2024-10-27
+		{"night shift over DST end", "sat 22:00-06:00", berlin, time.Date(2024, 10, 27, 4, 59, 0, 0, time.UTC), true, time.Date(2024, 10, 27, 4, 59, 0, 0, time.UTC)},
+		{"night shift over DST end end", "sat 22:00-06:00", berlin, time.Date(2024, 10, 27, 5, 0, 0, 0, time.UTC), false, time.Date(2024, 11, 2, 21, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s := newTestSchedule(t, tt.week, tt.loc)
+		if got := s.IsWorkingTimeAt(tt.at); got != tt.working {
+			t.Errorf("%s: IsWorkingTimeAt(%s) = %v, want %v", tt.name, tt.at, got, tt.working)
+		}
+		if got := s.GetNextWorkingTimeAt(tt.at); !got.Equal(tt.nextWork) {
+			t.Errorf("%s: GetNextWorkingTimeAt(%s) = %s, want %s", tt.name, tt.at, got.UTC(), tt.nextWork)
+		}
+	}
+}
+
+func TestParseOvernightWindows(t *testing.T) {
+	for _, good := range []string{
+		"mon 22:00-06:00",
+		"mon 09:00-12:00,22:00-02:00",
+		"mon 18:00-00:00",
+		"fri 22:00-06:00; sat 06:00-12:00",
+		"mon-sun 22:00-06:00",
+	} {
+		if _, err := parseWeek(good); err != nil {
+			t.Errorf("parseWeek(%q): %v", good, err)
+		}
+	}
+	for _, bad := range []string{
+		"mon 22:00-06:00,23:00-23:30",
+		"mon 09:00-09:00",
+		// The night shift runs into the next day's first window
+		"fri 22:00-06:00; sat 05:00-12:00",
+		"sun 20:00-02:00; mon 01:00-09:00",
+	} {
+		if _, err := parseWeek(bad); err == nil {
+			t.Errorf("parseWeek(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestNextTransitionAt(t *testing.T) {
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name string
+		week string
+		at   time.Time
+		want time.Time
+	}{
+		// 2024-06-03 is a Monday
+		{"before start", "mon-fri 09:00-18:00", at(6, 3, 8, 0), at(6, 3, 9, 0)},
+		{"at start", "mon-fri 09:00-18:00", at(6, 3, 9, 0), at(6, 3, 18, 0)},
+		{"lunch break", "mon-fri 09:00-12:00,13:00-18:00", at(6, 3, 10, 0), at(6, 3, 12, 0)},
+		{"adjacent windows", "mon-fri 09:00-12:00,12:00-18:00", at(6, 3, 10, 0), at(6, 3, 18, 0)},
+		{"overnight into day", "mon 22:00-06:00; tue 06:00-10:00", at(6, 3, 23, 0), at(6, 4, 10, 0)},
+		{"weekend", "mon-fri 09:00-18:00", at(6, 7, 18, 0), at(6, 10, 9, 0)},
+		{"no windows", "", at(6, 3, 10, 0), time.Time{}},
+	}
+	for _, tt := range tests {
+		s := newTestSchedule(t, tt.week, time.UTC)
+		if got := s.NextTransitionAt(tt.at); !got.Equal(tt.want) {
+			t.Errorf("%s: NextTransitionAt(%s) = %s, want %s", tt.name, tt.at, got, tt.want)
+		}
+	}
+
+	cron := newCronSchedule(t, "0 9 * * MON | 2h; 0 10 * * MON | 2h")
+	if got, want := cron.NextTransitionAt(at(6, 3, 9, 30)), at(6, 3, 12, 0); !got.Equal(want) {
+		t.Errorf("overlapping cron windows: NextTransitionAt = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,352 @@
+package schedule
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Source is a set of times at which something is active, such as working
+// hours, calendar events or a combination of other sources.
+type Source interface {
+	// ActiveAt reports whether t falls within the source.
+	ActiveAt(t time.Time) bool
+	// NextChange returns the first time after t at which ActiveAt changes,
+	// or the zero time if it never does.
+	NextChange(t time.Time) time.Time
+}
+
+// explainer is implemented by sources that can tell why they are active or
+// not.
+type explainer interface {
+	explain(t time.Time) string
+}
+
+func explainSource(s Source, t time.Time) string {
+	if e, ok := s.(explainer); ok {
+		return e.explain(t)
+	}
+	if s.ActiveAt(t) {
+		return "active"
+	}
+	return "inactive"
+}
+
+// ActiveAt makes a Schedule a Source; it is the same as IsWorkingTimeAt.
+func (s *Schedule) ActiveAt(t time.Time) bool {
+	return s.IsWorkingTimeAt(t)
+}
+
+// NextChange makes a Schedule a Source; it is the same as NextTransitionAt.
+func (s *Schedule) NextChange(t time.Time) time.Time {
+	return s.NextTransitionAt(t)
+}
+
+// ActiveAt reports whether an event of the calendar covers t.
+func (c *Calendar) ActiveAt(t time.Time) bool {
+	_, ok := c.blockedUntil(t)
+	return ok
+}
+
+// NextChange returns the next time an event of the calendar starts or the
+// events covering t are all over.
+func (c *Calendar) NextChange(t time.Time) time.Time {
+	if until, ok := c.blockedUntil(t); ok {
+		// Events that start exactly when others end continue the block
+		for i := 0; i < maxSearch; i++ {
+			next, ok := c.blockedUntil(until)
+			if !ok {
+				break
+			}
+			until = next
+		}
+		return until
+	}
+	return c.nextBlockStart(t)
+}
+
+func (c *Calendar) explain(t time.Time) string {
+	if summary, i, ok := c.eventAt(t); ok {
+		return fmt.Sprintf("calendar event %q from %s to %s", summary, formatTime(i.start.In(t.Location())), formatTime(i.end.In(t.Location())))
+	}
+	return "no calendar event"
+}
+
+// holidaySource is the set of public holidays.
+type holidaySource struct {
+	h *holidays
+}
+
+func (s holidaySource) ActiveAt(t time.Time) bool {
+	_, ok := s.h.blockedUntil(t)
+	return ok
+}
+
+func (s holidaySource) NextChange(t time.Time) time.Time {
+	if until, ok := s.h.blockedUntil(t); ok {
+		return until
+	}
+	return s.h.nextBlockStart(t)
+}
+
+func (s holidaySource) explain(t time.Time) string {
+	if name, _, ok := s.h.holidayAt(t); ok {
+		return "public holiday " + name
+	}
+	return "no public holiday"
+}
+
+// ruleSource is the set of working windows of weekly or cron rules.
+type ruleSource struct {
+	rules ruleSet
+}
+
+func (s ruleSource) ActiveAt(t time.Time) bool {
+	return s.rules.inWindow(t)
+}
+
+func (s ruleSource) NextChange(t time.Time) time.Time {
+	if s.rules.inWindow(t) {
+		return s.rules.windowEnd(t)
+	}
+	return s.rules.nextWindowStart(t)
+}
+
+func (s ruleSource) explain(t time.Time) string {
+	return s.rules.explain(t)
+}
+
+// combined is a source built from other sources with a set operation.
+type combined struct {
+	op      string
+	sources []Source
+	active  func(states []bool) bool
+}
+
+// Union is active whenever any of the sources is.
+func Union(sources ...Source) Source {
+	return &combined{op: "union", sources: sources, active: func(states []bool) bool {
+		for _, state := range states {
+			if state {
+				return true
+			}
+		}
+		return false
+	}}
+}
+
+// Intersection is active whenever all of the sources are.
+func Intersection(sources ...Source) Source {
+	return &combined{op: "intersection", sources: sources, active: func(states []bool) bool {
+		for _, state := range states {
+			if !state {
+				return false
+			}
+		}
+		return len(states) > 0
+	}}
+}
+
+// Except is active whenever base is and none of the excluded sources are.
+func Except(base Source, excluded ...Source) Source {
+	return &combined{op: "except", sources: append([]Source{base}, excluded...), active: func(states []bool) bool {
+		for _, state := range states[1:] {
+			if state {
+				return false
+			}
+		}
+		return states[0]
+	}}
+}
+
+func (c *combined) ActiveAt(t time.Time) bool {
+	states := make([]bool, len(c.sources))
+	for i, s := range c.sources {
+		states[i] = s.ActiveAt(t)
+	}
+	return c.active(states)
+}
+
+// NextChange steps through the changes of the sources until the combined
+// result changes.
+func (c *combined) NextChange(t time.Time) time.Time {
+	was := c.ActiveAt(t)
+	for i := 0; i < maxSearch; i++ {
+		var next time.Time
+		for _, s := range c.sources {
+			if change := s.NextChange(t); !change.IsZero() && (next.IsZero() || change.Before(next)) {
+				next = change
+			}
+		}
+		if next.IsZero() || c.ActiveAt(next) != was {
+			return next
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+// explain reports the sources that decided the combined result.
+func (c *combined) explain(t time.Time) string {
+	if c.op == "except" {
+		base := explainSource(c.sources[0], t)
+		if !c.sources[0].ActiveAt(t) {
+			return base
+		}
+		var excluded []string
+		for _, s := range c.sources[1:] {
+			if s.ActiveAt(t) {
+				excluded = append(excluded, explainSource(s, t))
+			}
+		}
+		if len(excluded) > 0 {
+			return fmt.Sprintf("%s, but excluded by %s", base, strings.Join(excluded, " and "))
+		}
+		return base
+	}
+
+	var active, inactive []string
+	for _, s := range c.sources {
+		if s.ActiveAt(t) {
+			active = append(active, explainSource(s, t))
+		} else {
+			inactive = append(inactive, explainSource(s, t))
+		}
+	}
+	switch {
+	case c.op == "union" && len(active) == 0:
+		return fmt.Sprintf("none of the combined sources is active (%s)", strings.Join(inactive, "; "))
+	case c.op == "intersection" && len(inactive) > 0:
+		return fmt.Sprintf("not all of the intersected sources are active (%s)", strings.Join(inactive, "; "))
+	}
+	return strings.Join(active, " and ")
+}
+
+// sourceRules adapts a source to the rules of a plan, so holidays, jitter
+// and overrides apply on top of it.
+type sourceRules struct {
+	source Source
+}
+
+func (r *sourceRules) inWindow(t time.Time) bool {
+	return r.source.ActiveAt(t)
+}
+
+func (r *sourceRules) nextWindowStart(t time.Time) time.Time {
+	for i := 0; i < maxSearch; i++ {
+		t = r.source.NextChange(t)
+		if t.IsZero() || r.source.ActiveAt(t) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (r *sourceRules) windowEnd(t time.Time) time.Time {
+	return r.source.NextChange(t)
+}
+
+func (r *sourceRules) explain(t time.Time) string {
+	return explainSource(r.source, t)
+}
+
+// SourceConfig describes a source in the schedule file. Exactly one of the
+// fields must be set: a leaf with weekly hours, cron rules, calendars or
+// public holidays, or a combination of other sources.
+type SourceConfig struct {
+	WorkHours    string         `json:"work_hours,omitempty"`
+	WorkCron     string         `json:"work_cron,omitempty"`
+	Calendars    []string       `json:"calendars,omitempty"`
+	Holidays     []string       `json:"holidays,omitempty"`
+	Union        []SourceConfig `json:"union,omitempty"`
+	Intersection []SourceConfig `json:"intersection,omitempty"`
+	// Except is active when its first source is and none of the others are.
+	Except []SourceConfig `json:"except,omitempty"`
+}
+
+// build turns the config into a source evaluated in loc.
+func (c *SourceConfig) build(loc *time.Location) (Source, error) {
+	set := 0
+	for _, isSet := range []bool{c.WorkHours != "", c.WorkCron != "", len(c.Calendars) > 0, len(c.Holidays) > 0, len(c.Union) > 0, len(c.Intersection) > 0, len(c.Except) > 0} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("each source needs exactly one of work_hours, work_cron, calendars, holidays, union, intersection or except")
+	}
+
+	switch {
+	case c.WorkHours != "":
+		days, err := parseWeek(c.WorkHours)
+		if err != nil {
+			return nil, err
+		}
+		return ruleSource{&weeklyRules{days: days, loc: loc}}, nil
+	case c.WorkCron != "":
+		rules, err := parseCronRules(c.WorkCron, loc)
+		if err != nil {
+			return nil, err
+		}
+		return ruleSource{rules}, nil
+	case len(c.Calendars) > 0:
+		return LoadCalendar(loc, c.Calendars...)
+	case len(c.Holidays) > 0:
+		h, err := newHolidays(loc, c.Holidays)
+		if err != nil {
+			return nil, err
+		}
+		return holidaySource{h}, nil
+	}
+
+	children := c.Union
+	switch {
+	case len(c.Intersection) > 0:
+		children = c.Intersection
+	case len(c.Except) > 0:
+		children = c.Except
+	}
+	sources := make([]Source, len(children))
+	for i := range children {
+		source, err := children[i].build(loc)
+		if err != nil {
+			return nil, err
+		}
+		sources[i] = source
+	}
+
+	switch {
+	case len(c.Intersection) > 0:
+		return Intersection(sources...), nil
+	case len(c.Except) > 0:
+		return Except(sources[0], sources[1:]...), nil
+	default:
+		return Union(sources...), nil
+	}
+}
+
+// calendars returns the calendar files the source reads.
+func (c *SourceConfig) calendars() []string {
+	files := append([]string(nil), c.Calendars...)
+	for _, children := range [][]SourceConfig{c.Union, c.Intersection, c.Except} {
+		for i := range children {
+			files = append(files, children[i].calendars()...)
+		}
+	}
+	return files
+}
+
+// resolve makes relative calendar paths relative to dir.
+func (c *SourceConfig) resolve(dir string) {
+	for i, calendar := range c.Calendars {
+		if !filepath.IsAbs(calendar) {
+			c.Calendars[i] = filepath.Join(dir, calendar)
+		}
+	}
+	for _, children := range [][]SourceConfig{c.Union, c.Intersection, c.Except} {
+		for i := range children {
+			children[i].resolve(dir)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package schedule
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func weeklySource(t *testing.T, week string) Source {
+	t.Helper()
+	days, err := parseWeek(week)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ruleSource{&weeklyRules{days: days, loc: time.UTC}}
+}
+
+func TestCombinators(t *testing.T) {
+	core := weeklySource(t, "mon-fri 10:00-16:00")
+	blocks := weeklySource(t, "mon 12:00-13:00; tue 09:00-11:00")
+	oncall := weeklySource(t, "mon 15:00-20:00")
+	// 2024-06-03 is a Monday
+	at := func(day, hour int) time.Time {
+		return time.Date(2024, 6, day, hour, 0, 0, 0, time.UTC)
+	}
+
+	s := Union(Except(core, blocks), oncall)
+	tests := []struct {
+		t          time.Time
+		want       bool
+		nextChange time.Time
+	}{
+		{at(3, 9), false, at(3, 10)},
+		{at(3, 11), true, at(3, 12)},
+		{at(3, 12), false, at(3, 13)},
+		// The on-call shift continues the core hours
+		{at(3, 15), true, at(3, 20)},
+		{at(4, 10), false, at(4, 11)},
+		{at(4, 11), true, at(4, 16)},
+	}
+	for _, tt := range tests {
+		if got := s.ActiveAt(tt.t); got != tt.want {
+			t.Errorf("ActiveAt(%v) = %v, want %v", tt.t, got, tt.want)
+		}
+		if got := s.NextChange(tt.t); !got.Equal(tt.nextChange) {
+			t.Errorf("NextChange(%v) = %v, want %v", tt.t, got, tt.nextChange)
+		}
+	}
+
+	both := Intersection(core, oncall)
+	if !both.ActiveAt(at(3, 15)) || both.ActiveAt(at(3, 17)) {
+		t.Error("intersection should be active from 15:00 to 16:00 only")
+	}
+	if got := both.NextChange(at(3, 9)); !got.Equal(at(3, 15)) {
+		t.Errorf("intersection NextChange = %v, want 15:00", got)
+	}
+}
+
+func TestComposeConfig(t *testing.T) {
+	dir := t.TempDir()
+	oncall := "BEGIN:VCALENDAR\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:On call\r\n" +
+		"DTSTART:20240608T080000Z\r\n" +
+		"DTEND:20240608T200000Z\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	if err := os.WriteFile(filepath.Join(dir, "oncall.ics"), []byte(oncall), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "schedule.json")
+	config := `{
+		"timezone": "UTC",
+		"compose": {"union": [
+			{"except": [{"work_hours": "mon-fri 09:00-17:00"}, {"work_hours": "mon-fri 12:00-13:00"}]},
+			{"calendars": ["oncall.ics"]}
+		]}
+	}`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := c.build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Schedule{plan: p, path: path}
+
+	if s.IsWorkingTimeAt(time.Date(2024, 6, 3, 12, 30, 0, 0, time.UTC)) {
+		t.Error("the lunch block should be excluded")
+	}
+	if !s.IsWorkingTimeAt(time.Date(2024, 6, 8, 10, 0, 0, 0, time.UTC)) {
+		t.Error("the on-call event should be working time on Saturday")
+	}
+	if got, want := s.GetNextWorkingTimeAt(time.Date(2024, 6, 7, 18, 0, 0, 0, time.UTC)), time.Date(2024, 6, 8, 8, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("GetNextWorkingTimeAt = %v, want %v", got, want)
+	}
+	if explanation := s.Explain(time.Date(2024, 6, 8, 10, 0, 0, 0, time.UTC)).String(); !strings.Contains(explanation, `"On call"`) {
+		t.Errorf("Explain does not mention the calendar event:\n%s", explanation)
+	}
+
+	// The referenced calendar is watched for changes
+	if stamps := s.stamps(); len(stamps) != 2 {
+		t.Errorf("watching %d files, want the schedule and the calendar", len(stamps))
+	}
+
+	for _, invalid := range []string{
+		`{"compose": {}}`,
+		`{"compose": {"work_hours": "mon 09:00-10:00", "work_cron": "0 9 * * * | 1h"}}`,
+		`{"work_hours": "mon 09:00-10:00", "compose": {"work_hours": "mon 09:00-10:00"}}`,
+		`{"compose": {"holidays": ["XX"]}}`,
+	} {
+		if err := os.WriteFile(path, []byte(invalid), 0644); err != nil {
+			t.Fatal(err)
+		}
+		c, err := LoadConfig(path)
+		if err == nil {
+			_, err = c.build()
+		}
+		if err == nil {
+			t.Errorf("config %s should be rejected", invalid)
+		}
+	}
+}
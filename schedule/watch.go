@@ -0,0 +1,115 @@
+package schedule
+
+import (
+	"context"
+	"time"
+
+	"github.com/lucy/slack-always-active/clock"
+)
+
+// EventType tells whether working time starts or ends.
+type EventType int
+
+const (
+	Start EventType = iota
+	End
+)
+
+func (t EventType) String() string {
+	if t == Start {
+		return "start"
+	}
+	return "end"
+}
+
+// Event is a working time transition.
+type Event struct {
+	Type EventType
+	Time time.Time
+}
+
+// changes returns a channel that is closed the next time the schedule is
+// reloaded or the override changes.
+func (s *Schedule) changes() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.changed == nil {
+		s.changed = make(chan struct{})
+	}
+	return s.changed
+}
+
+// notifyLocked wakes the watchers waiting on changes. s.mu must be held.
+func (s *Schedule) notifyLocked() {
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+}
+
+// Reevaluate makes the watchers check the current state right away. Call it
+// when the wall clock jumped, for example after the host resumed from sleep,
+// since timers only count time the host was awake.
+func (s *Schedule) Reevaluate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifyLocked()
+}
+
+// Watch emits an event for the current state right away and then one at
+// every transition between working and non-working time. The schedule is
+// re-evaluated when it is reloaded or the override changes. The channel is
+// closed when ctx is cancelled.
+func (s *Schedule) Watch(ctx context.Context) <-chan Event {
+	events := make(chan Event)
+
+	go func() {
+		defer close(events)
+
+		var timer clock.Timer
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+
+		emitted := false
+		working := false
+		for {
+			changed := s.changes()
+			now := s.now()
+
+			if isWorking := s.IsWorkingTimeAt(now); !emitted || isWorking != working {
+				event := Event{Type: End, Time: now.In(s.Location())}
+				if isWorking {
+					event.Type = Start
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+				emitted, working = true, isWorking
+			}
+
+			// Sleep until the next transition; with none ahead, wait for a reload
+			var wake <-chan time.Time
+			if next := s.NextTransitionAt(now); !next.IsZero() {
+				timer = s.getClock().NewTimer(next.Sub(now))
+				wake = timer.C()
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-wake:
+			case <-changed:
+				if timer != nil {
+					timer.Stop()
+				}
+			}
+		}
+	}()
+
+	return events
+}
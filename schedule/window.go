@@ -0,0 +1,310 @@
+package schedule
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// timeOfDay is a wall-clock time within a day, in minutes since midnight.
+type timeOfDay int
+
+const endOfDay timeOfDay = 24 * 60
+
+func (t timeOfDay) String() string {
+	return fmt.Sprintf("%02d:%02d", t/60, t%60)
+}
+
+// on returns the time of day on the given date in loc. A time skipped by a
+// DST change is read with the offset before the change, so 02:30 on a day
+// that springs forward from 02:00 to 03:00 becomes 03:30; a time repeated
+// when clocks fall back resolves to its second occurrence.
+func (t timeOfDay) on(year int, month time.Month, day int, loc *time.Location) time.Time {
+	return time.Date(year, month, day, int(t/60), int(t%60), 0, 0, loc)
+}
+
+// window is a span of working hours starting on a day. A window whose end
+// is not after its start wraps past midnight into the following day.
+type window struct {
+	start timeOfDay
+	end   timeOfDay
+}
+
+func (w window) wraps() bool {
+	return w.end <= w.start
+}
+
+// interval returns the concrete span of the window starting on the given date.
+func (w window) interval(year int, month time.Month, day int, loc *time.Location) interval {
+	endDay := day
+	if w.wraps() {
+		endDay++
+	}
+	return interval{
+		start: w.start.on(year, month, day, loc),
+		end:   w.end.on(year, month, endDay, loc),
+	}
+}
+
+func (w window) String() string {
+	return fmt.Sprintf("%s-%s", w.start, w.end)
+}
+
+// interval is a concrete half-open span of time [start, end).
+type interval struct {
+	start time.Time
+	end   time.Time
+}
+
+func (i interval) contains(t time.Time) bool {
+	return !t.Before(i.start) && t.Before(i.end)
+}
+
+// weeklyRules is a schedule made of windows that repeat every week.
+type weeklyRules struct {
+	days [7][]window
+	loc  *time.Location
+	// exceptions replace the windows of specific dates.
+	exceptions map[date][]window
+	// last is the latest exception date, or the zero time.
+	last time.Time
+}
+
+// windowsOn returns the windows that start on the given day and whether
+// they come from a date-specific exception.
+func (r *weeklyRules) windowsOn(year int, month time.Month, day int) ([]window, bool) {
+	if windows, ok := r.exceptions[dateOn(year, month, day)]; ok {
+		return windows, true
+	}
+	weekday := time.Date(year, month, day, 12, 0, 0, 0, r.loc).Weekday()
+	return r.days[weekday], false
+}
+
+// intervalsOn returns the working periods that start on the given day, in
+// order. They are built in the schedule location, so DST transitions are
+// respected; an overnight window ends on the following day.
+func (r *weeklyRules) intervalsOn(year int, month time.Month, day int) []interval {
+	windows, _ := r.windowsOn(year, month, day)
+	intervals := make([]interval, 0, len(windows))
+	for _, w := range windows {
+		intervals = append(intervals, w.interval(year, month, day, r.loc))
+	}
+	return intervals
+}
+
+// inWindow reports whether t falls within one of the weekly windows.
+func (r *weeklyRules) inWindow(t time.Time) bool {
+	now := t.In(r.loc)
+
+	// Overnight windows from yesterday may still be running
+	for day := now.Day() - 1; day <= now.Day(); day++ {
+		for _, i := range r.intervalsOn(now.Year(), now.Month(), day) {
+			if i.contains(now) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nextWindowStart returns the start of the first weekly window after t, or
+// the zero time if there are none. The search covers a full week past the
+// last exception, since exceptions may mark any number of days off.
+func (r *weeklyRules) nextWindowStart(t time.Time) time.Time {
+	now := t.In(r.loc)
+	for i := 0; i <= 7 || !time.Date(now.Year(), now.Month(), now.Day()+i-7, 12, 0, 0, 0, r.loc).After(r.last); i++ {
+		for _, next := range r.intervalsOn(now.Year(), now.Month(), now.Day()+i) {
+			if next.start.After(now) {
+				return next.start
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// windowEnd returns the end of the working period containing t, following
+// windows that start exactly where the previous one ends.
+func (r *weeklyRules) windowEnd(t time.Time) time.Time {
+	end := t.In(r.loc)
+	for i := 0; i < maxSearch && r.inWindow(end); i++ {
+		for day := end.Day() - 1; day <= end.Day(); day++ {
+			for _, w := range r.intervalsOn(end.Year(), end.Month(), day) {
+				if w.contains(end) {
+					end = w.end
+				}
+			}
+		}
+	}
+	return end
+}
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+func parseTimeOfDay(timeStr string) (timeOfDay, error) {
+	parts := strings.Split(strings.TrimSpace(timeStr), ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time format: %s", timeStr)
+	}
+
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 24 {
+		return 0, fmt.Errorf("invalid hour: %s", parts[0])
+	}
+
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid minute: %s", parts[1])
+	}
+
+	return timeOfDay(hour*60 + minute), nil
+}
+
+// parseWindow parses a single window such as "09:00-12:00".
+func parseWindow(windowStr string) (window, error) {
+	startStr, endStr, ok := strings.Cut(windowStr, "-")
+	if !ok {
+		return window{}, fmt.Errorf("invalid window: %s", windowStr)
+	}
+
+	start, err := parseTimeOfDay(startStr)
+	if err != nil {
+		return window{}, err
+	}
+	end, err := parseTimeOfDay(endStr)
+	if err != nil {
+		return window{}, err
+	}
+
+	if start == endOfDay {
+		return window{}, fmt.Errorf("invalid window %s: cannot start at 24:00", windowStr)
+	}
+	if end == start {
+		return window{}, fmt.Errorf("invalid window %s: start and end are equal", windowStr)
+	}
+
+	return window{start: start, end: end}, nil
+}
+
+// parseDays parses a comma-separated list of days and day ranges such as
+// "mon-thu" or "mon,wed,fri". Ranges may wrap around the week ("fri-mon").
+func parseDays(daysStr string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, part := range strings.Split(daysStr, ",") {
+		part = strings.TrimSpace(strings.ToLower(part))
+		fromStr, toStr, isRange := strings.Cut(part, "-")
+
+		from, ok := dayNames[fromStr]
+		if !ok {
+			return nil, fmt.Errorf("invalid day: %s", fromStr)
+		}
+		if !isRange {
+			days = append(days, from)
+			continue
+		}
+
+		to, ok := dayNames[toStr]
+		if !ok {
+			return nil, fmt.Errorf("invalid day: %s", toStr)
+		}
+		for day := from; ; day = (day + 1) % 7 {
+			days = append(days, day)
+			if day == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// cutWindows splits an entry such as "mon, tue 09:00-17:00" at the
+// whitespace before its first window, so the day list may contain spaces.
+func cutWindows(entry string) (daysStr, windowsStr string, ok bool) {
+	i := strings.IndexFunc(entry, unicode.IsDigit)
+	if i <= 0 || !unicode.IsSpace(rune(entry[i-1])) {
+		return "", "", false
+	}
+	return strings.TrimSpace(entry[:i]), entry[i:], true
+}
+
+// parseWeek parses a weekly schedule such as
+// "mon-thu 09:00-12:00,13:00-18:00; fri 09:00-14:00". Days that are not
+// listed have no working hours.
+func parseWeek(weekStr string) ([7][]window, error) {
+	var week [7][]window
+
+	for _, entry := range strings.Split(weekStr, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		daysStr, windowsStr, ok := cutWindows(entry)
+		if !ok {
+			return week, fmt.Errorf("invalid entry %q: expected days followed by windows", entry)
+		}
+
+		days, err := parseDays(daysStr)
+		if err != nil {
+			return week, fmt.Errorf("invalid entry %q: %v", entry, err)
+		}
+
+		var windows []window
+		for _, windowStr := range strings.Split(windowsStr, ",") {
+			w, err := parseWindow(windowStr)
+			if err != nil {
+				return week, fmt.Errorf("invalid entry %q: %v", entry, err)
+			}
+			windows = append(windows, w)
+		}
+
+		for _, day := range days {
+			week[day] = append(week[day], windows...)
+		}
+	}
+
+	for day := range week {
+		if err := normalizeWindows(week[day]); err != nil {
+			return week, fmt.Errorf("%s: %v", time.Weekday(day), err)
+		}
+	}
+
+	// A window running past midnight must end before the next day's first
+	// window starts
+	for day := range week {
+		next := (day + 1) % 7
+		if len(week[day]) == 0 || len(week[next]) == 0 {
+			continue
+		}
+		last, first := week[day][len(week[day])-1], week[next][0]
+		if last.wraps() && first.start < last.end {
+			return week, fmt.Errorf("window %s on %s overlaps %s on %s", last, time.Weekday(day), first, time.Weekday(next))
+		}
+	}
+
+	return week, nil
+}
+
+// normalizeWindows sorts windows by start time and rejects overlaps. Only
+// the last window of a day may wrap past midnight.
+func normalizeWindows(windows []window) error {
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i].start < windows[j].start
+	})
+	for i := 1; i < len(windows); i++ {
+		if windows[i-1].wraps() || windows[i].start < windows[i-1].end {
+			return fmt.Errorf("windows %s and %s overlap", windows[i-1], windows[i])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package slackws
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Envelope is the part every RTM message has in common. It is decoded once
+// to find out which type to decode the message as.
+type Envelope struct {
+	Type    string `json:"type"`
+	Subtype string `json:"subtype,omitempty"`
+}
+
+type HelloEvent struct {
+	Type   string `json:"type"`
+	Region string `json:"region"`
+	HostID string `json:"host_id"`
+	Start  bool   `json:"start"`
+}
+
+type MessageEvent struct {
+	Type     string `json:"type"`
+	Subtype  string `json:"subtype,omitempty"`
+	Channel  string `json:"channel"`
+	User     string `json:"user"`
+	BotID    string `json:"bot_id,omitempty"`
+	Text     string `json:"text"`
+	TS       string `json:"ts"`
+	ThreadTS string `json:"thread_ts,omitempty"`
+}
+
+// PresenceChangeEvent reports a presence change of one user, or of several
+// in Users when presence subscriptions are batched.
+type PresenceChangeEvent struct {
+	Type     string   `json:"type"`
+	User     string   `json:"user,omitempty"`
+	Users    []string `json:"users,omitempty"`
+	Presence string   `json:"presence"`
+}
+
+// ManualPresenceChangeEvent reports that the own presence was set by hand.
+type ManualPresenceChangeEvent struct {
+	Type     string `json:"type"`
+	Presence string `json:"presence"`
+}
+
+type UserTypingEvent struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+	User    string `json:"user"`
+}
+
+// ReactionEvent is a reaction_added or reaction_removed event.
+type ReactionEvent struct {
+	Type     string `json:"type"`
+	User     string `json:"user"`
+	Reaction string `json:"reaction"`
+	ItemUser string `json:"item_user,omitempty"`
+	Item     struct {
+		Type    string `json:"type"`
+		Channel string `json:"channel"`
+		TS      string `json:"ts"`
+	} `json:"item"`
+	EventTS string `json:"event_ts"`
+}
+
+// GoodbyeEvent announces that Slack is about to close the connection.
+type GoodbyeEvent struct {
+	Type string `json:"type"`
+}
+
+// TeamMigrationStartedEvent announces that the workspace is moving to
+// another server and the connection will be closed.
+type TeamMigrationStartedEvent struct {
+	Type string `json:"type"`
+}
+
+// ErrorEvent is an error Slack reported on the connection.
+type ErrorEvent struct {
+	Type  string     `json:"type"`
+	Error SlackError `json:"error"`
+}
+
+// SlackError is the error in an error message from Slack.
+type SlackError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func (e *SlackError) Error() string {
+	return fmt.Sprintf("slack error %d: %s", e.Code, e.Msg)
+}
+
+// RawEvent is a message of a type without a Go struct, or one that is not
+// JSON at all, in which case Type is empty.
+type RawEvent struct {
+	Type string
+	Data json.RawMessage
+}
+
+// eventTypes maps the known RTM event types to the structs they decode to.
+var eventTypes = map[string]func() interface{}{
+	"hello":                  func() interface{} { return new(HelloEvent) },
+	"message":                func() interface{} { return new(MessageEvent) },
+	"presence_change":        func() interface{} { return new(PresenceChangeEvent) },
+	"manual_presence_change": func() interface{} { return new(ManualPresenceChangeEvent) },
+	"user_typing":            func() interface{} { return new(UserTypingEvent) },
+	"reaction_added":         func() interface{} { return new(ReactionEvent) },
+	"reaction_removed":       func() interface{} { return new(ReactionEvent) },
+	"goodbye":                func() interface{} { return new(GoodbyeEvent) },
+	"team_migration_started": func() interface{} { return new(TeamMigrationStartedEvent) },
+	"error":                  func() interface{} { return new(ErrorEvent) },
+	"pong":                   func() interface{} { return new(PongMessage) },
+	"reconnect_url":          func() interface{} { return new(ReconnectMessage) },
+}
+
+// Dispatcher decodes RTM messages and passes them to the handlers
+// registered for their type. Handlers run on the goroutine that reads the
+// connection, so they should return quickly. They must not call Connect,
+// Disconnect or Close on the SlackWebSocket: Disconnect waits for that
+// goroutine, so the call would never return.
+type Dispatcher struct {
+	mu       sync.RWMutex
+	handlers map[string][]func(interface{})
+	raw      []func(RawEvent)
+}
+
+// NewDispatcher returns a Dispatcher without handlers.
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{handlers: make(map[string][]func(interface{}))}
+}
+
+func (d *Dispatcher) on(eventType string, fn func(interface{})) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[eventType] = append(d.handlers[eventType], fn)
+}
+
+func (d *Dispatcher) OnHello(fn func(*HelloEvent)) {
+	d.on("hello", func(ev interface{}) { fn(ev.(*HelloEvent)) })
+}
+
+func (d *Dispatcher) OnMessage(fn func(*MessageEvent)) {
+	d.on("message", func(ev interface{}) { fn(ev.(*MessageEvent)) })
+}
+
+func (d *Dispatcher) OnPresenceChange(fn func(*PresenceChangeEvent)) {
+	d.on("presence_change", func(ev interface{}) { fn(ev.(*PresenceChangeEvent)) })
+}
+
+func (d *Dispatcher) OnManualPresenceChange(fn func(*ManualPresenceChangeEvent)) {
+	d.on("manual_presence_change", func(ev interface{}) { fn(ev.(*ManualPresenceChangeEvent)) })
+}
+
+func (d *Dispatcher) OnUserTyping(fn func(*UserTypingEvent)) {
+	d.on("user_typing", func(ev interface{}) { fn(ev.(*UserTypingEvent)) })
+}
+
+func (d *Dispatcher) OnReactionAdded(fn func(*ReactionEvent)) {
+	d.on("reaction_added", func(ev interface{}) { fn(ev.(*ReactionEvent)) })
+}
+
+func (d *Dispatcher) OnReactionRemoved(fn func(*ReactionEvent)) {
+	d.on("reaction_removed", func(ev interface{}) { fn(ev.(*ReactionEvent)) })
+}
+
+func (d *Dispatcher) OnGoodbye(fn func(*GoodbyeEvent)) {
+	d.on("goodbye", func(ev interface{}) { fn(ev.(*GoodbyeEvent)) })
+}
+
+func (d *Dispatcher) OnTeamMigrationStarted(fn func(*TeamMigrationStartedEvent)) {
+	d.on("team_migration_started", func(ev interface{}) { fn(ev.(*TeamMigrationStartedEvent)) })
+}
+
+func (d *Dispatcher) OnError(fn func(*ErrorEvent)) {
+	d.on("error", func(ev interface{}) { fn(ev.(*ErrorEvent)) })
+}
+
+// OnRaw registers a handler for messages whose type has no Go struct, or
+// has no handler registered for it.
+func (d *Dispatcher) OnRaw(fn func(RawEvent)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.raw = append(d.raw, fn)
+}
+
+// Dispatch decodes a message and passes it to the handlers for its type.
+// It reports whether any handler received it.
+func (d *Dispatcher) Dispatch(data []byte) (bool, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return d.dispatchRaw(RawEvent{Data: data}), nil
+	}
+	_, handled, err := d.dispatch(env.Type, data)
+	return handled, err
+}
+
+// dispatch decodes a message of the given type and passes it to the
+// handlers for that type, or to the raw handlers if there are none. It
+// returns the decoded event, or nil if the type is unknown, and whether any
+// handler received it.
+func (d *Dispatcher) dispatch(eventType string, data []byte) (interface{}, bool, error) {
+	newEvent, known := eventTypes[eventType]
+	if !known {
+		return nil, d.dispatchRaw(RawEvent{Type: eventType, Data: data}), nil
+	}
+
+	ev := newEvent()
+	if err := json.Unmarshal(data, ev); err != nil {
+		return nil, false, fmt.Errorf("error decoding %s event: %v", eventType, err)
+	}
+
+	d.mu.RLock()
+	handlers := d.handlers[eventType]
+	d.mu.RUnlock()
+	if len(handlers) == 0 {
+		return ev, d.dispatchRaw(RawEvent{Type: eventType, Data: data}), nil
+	}
+	for _, fn := range handlers {
+		fn(ev)
+	}
+	return ev, true, nil
+}
+
+func (d *Dispatcher) dispatchRaw(ev RawEvent) bool {
+	d.mu.RLock()
+	handlers := d.raw
+	d.mu.RUnlock()
+	for _, fn := range handlers {
+		fn(ev)
+	}
+	return len(handlers) > 0
+}
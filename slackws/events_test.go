@@ -0,0 +1,65 @@
+package slackws
+
+import (
+	"testing"
+)
+
+func TestDispatcherTypedHandlers(t *testing.T) {
+	d := NewDispatcher()
+	var messages []*MessageEvent
+	var presence []*PresenceChangeEvent
+	var raw []RawEvent
+	d.OnMessage(func(ev *MessageEvent) { messages = append(messages, ev) })
+	d.OnPresenceChange(func(ev *PresenceChangeEvent) { presence = append(presence, ev) })
+	d.OnRaw(func(ev RawEvent) { raw = append(raw, ev) })
+
+	inputs := []string{
+		`{"type":"message","channel":"C01","user":"U01","text":"hi","ts":"1717405200.000100"}`,
+		`{"type":"presence_change","users":["U01","U02"],"presence":"away"}`,
+		`{"type":"channel_marked","channel":"C01","ts":"1717405200.000100"}`,
+		`not json`,
+	}
+	for _, input := range inputs {
+		if _, err := d.Dispatch([]byte(input)); err != nil {
+			t.Fatalf("Dispatch(%s): %v", input, err)
+		}
+	}
+
+	if len(messages) != 1 || messages[0].Channel != "C01" || messages[0].Text != "hi" {
+		t.Errorf("messages = %+v", messages)
+	}
+	if len(presence) != 1 || presence[0].Presence != "away" || len(presence[0].Users) != 2 {
+		t.Errorf("presence = %+v", presence)
+	}
+	if len(raw) != 2 || raw[0].Type != "channel_marked" || raw[1].Type != "" || string(raw[1].Data) != "not json" {
+		t.Errorf("raw = %+v", raw)
+	}
+}
+
+func TestDispatcherReportsUnhandled(t *testing.T) {
+	d := NewDispatcher()
+	d.OnMessage(func(*MessageEvent) {})
+
+	handled, err := d.Dispatch([]byte(`{"type":"message","text":"hi"}`))
+	if err != nil || !handled {
+		t.Errorf("Dispatch(message) = %v, %v, want handled", handled, err)
+	}
+	handled, err = d.Dispatch([]byte(`{"type":"user_typing","channel":"C01","user":"U01"}`))
+	if err != nil || handled {
+		t.Errorf("Dispatch(user_typing) = %v, %v, want not handled", handled, err)
+	}
+	if _, err := d.Dispatch([]byte(`{"type":"message","text":42}`)); err == nil {
+		t.Error("a malformed message was decoded")
+	}
+
+	// Known types without a handler go to the raw handlers
+	var raw []RawEvent
+	d.OnRaw(func(ev RawEvent) { raw = append(raw, ev) })
+	handled, err = d.Dispatch([]byte(`{"type":"user_typing","channel":"C01","user":"U01"}`))
+	if err != nil || !handled {
+		t.Errorf("Dispatch(user_typing) = %v, %v, want handled by OnRaw", handled, err)
+	}
+	if len(raw) != 1 || raw[0].Type != "user_typing" {
+		t.Errorf("raw = %+v", raw)
+	}
+}
@@ -0,0 +1,67 @@
+package slackws
+
+import "time"
+
+// State is a stage in the life of a SlackWebSocket connection.
+type State int
+
+const (
+	// Idle is the state before the first connection.
+	Idle State = iota
+	// Dialing means a connection is being opened.
+	Dialing
+	// AwaitingHello means the connection is open and Slack has yet to
+	// greet it with a hello message.
+	AwaitingHello
+	// Live means Slack said hello and the connection shows us as active.
+	Live
+	// Draining means the connection is being closed gracefully.
+	Draining
+	// Closed means the last connection is gone. Connect starts over.
+	Closed
+)
+
+func (s State) String() string {
+	switch s {
+	case Idle:
+		return "idle"
+	case Dialing:
+		return "dialing"
+	case AwaitingHello:
+		return "awaiting hello"
+	case Live:
+		return "live"
+	case Draining:
+		return "draining"
+	case Closed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// transitions lists the states each state may move to.
+var transitions = map[State][]State{
+	Idle:          {Dialing},
+	Dialing:       {AwaitingHello, Closed},
+	AwaitingHello: {Live, Draining, Closed},
+	Live:          {Draining, Closed},
+	Draining:      {Closed},
+	Closed:        {Dialing},
+}
+
+// canTransition reports whether a connection may move from one state to
+// another.
+func canTransition(from, to State) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StateChange is a transition between two states.
+type StateChange struct {
+	From, To State
+	At       time.Time
+}
@@ -0,0 +1,216 @@
+package slackws
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lucy/slack-always-active/clock"
+)
+
+// recorder collects the state changes of a SlackWebSocket and checks that
+// each one is allowed and follows on from the one before.
+type recorder struct {
+	t       *testing.T
+	mu      sync.Mutex
+	last    State
+	changes []StateChange
+	seen    chan State
+}
+
+func record(t *testing.T, s *SlackWebSocket) *recorder {
+	r := &recorder{t: t, last: s.State(), seen: make(chan State, 1000)}
+	s.OnStateChange(func(change StateChange) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if change.From != r.last {
+			r.t.Errorf("change %s -> %s does not follow on from %s", change.From, change.To, r.last)
+		}
+		if !canTransition(change.From, change.To) {
+			r.t.Errorf("invalid change %s -> %s", change.From, change.To)
+		}
+		r.last = change.To
+		r.changes = append(r.changes, change)
+		select {
+		case r.seen <- change.To:
+		default:
+		}
+	})
+	return r
+}
+
+// waitFor waits until the state was reached.
+func (r *recorder) waitFor(state State) {
+	r.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case seen := <-r.seen:
+			if seen == state {
+				return
+			}
+		case <-timeout:
+			r.t.Fatalf("state %s was not reached", state)
+		}
+	}
+}
+
+func (r *recorder) states() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var states []string
+	for _, change := range r.changes {
+		states = append(states, change.To.String())
+	}
+	return strings.Join(states, ", ")
+}
+
+func TestStateLifecycle(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC))
+	s, _ := newTestSocket(t, newServer(t, true)+"/primary/")
+	s.SetClock(clk)
+	r := record(t, s)
+	if s.State() != Idle {
+		t.Fatalf("State() = %s before connecting, want idle", s.State())
+	}
+
+	// Connect returns once Slack said hello
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if s.State() != Live || !s.IsConnected() {
+		t.Fatalf("State() = %s after Connect, want live", s.State())
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ReadMessages()
+	}()
+	// Wait for ReadMessages to start the ping and rotation tickers
+	clk.BlockUntil(2)
+
+	s.Disconnect()
+	if err := <-done; err != nil {
+		t.Errorf("ReadMessages() = %v after Disconnect, want nil", err)
+	}
+	if s.State() != Closed || s.IsConnected() {
+		t.Errorf("State() = %s after Disconnect, want closed", s.State())
+	}
+
+	want := "dialing, awaiting hello, live, draining, closed"
+	if got := r.states(); got != want {
+		t.Errorf("states = %s, want %s", got, want)
+	}
+}
+
+func TestDisconnectWhileDialing(t *testing.T) {
+	release := make(chan struct{})
+	upgrader := websocket.Upgrader{Subprotocols: []string{"slack"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	s, _ := newTestSocket(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/primary/")
+	r := record(t, s)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Connect()
+	}()
+	r.waitFor(Dialing)
+
+	// Disconnect gives up the dial without waiting for the server
+	s.Disconnect()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Connect succeeded after Disconnect")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connect still dialing after Disconnect")
+	}
+	if got, want := r.states(), "dialing, closed"; got != want {
+		t.Errorf("states = %s, want %s", got, want)
+	}
+}
+
+func TestFlappingConnection(t *testing.T) {
+	// The server refuses some connections, drops others after a moment and
+	// sometimes never says hello
+	var mu sync.Mutex
+	random := rand.New(rand.NewSource(1))
+	intn := func(n int) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return random.Intn(n)
+	}
+	upgrader := websocket.Upgrader{Subprotocols: []string{"slack"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if intn(4) == 0 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if intn(3) > 0 {
+			conn.WriteJSON(HelloEvent{Type: "hello"})
+		}
+		time.Sleep(time.Duration(intn(20)) * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	s, _ := newTestSocket(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/primary/")
+	r := record(t, s)
+	sup := NewSupervisor(s, Backoff{
+		Initial:    time.Millisecond,
+		Max:        5 * time.Millisecond,
+		Multiplier: 2,
+		Threshold:  1000,
+		Probe:      time.Second,
+	})
+
+	// Switch the connection on and off from several goroutines while the
+	// server keeps dropping it
+	var wg sync.WaitGroup
+	for g := 0; g < 3; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if (i+g)%2 == 0 {
+					sup.Connect()
+				} else {
+					sup.Disconnect()
+				}
+				s.IsConnected()
+				s.State()
+				time.Sleep(time.Duration(intn(10)) * time.Millisecond)
+			}
+		}(g)
+	}
+	wg.Wait()
+	sup.Disconnect()
+
+	if state := s.State(); state != Closed {
+		t.Errorf("State() = %s after Disconnect, want closed", state)
+	}
+	r.mu.Lock()
+	changes := len(r.changes)
+	r.mu.Unlock()
+	if changes < 10 {
+		t.Errorf("only %d state changes, the connection did not flap: %s", changes, r.states())
+	}
+}
@@ -0,0 +1,252 @@
+package slackws
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/lucy/slack-always-active/clock"
+	"github.com/lucy/slack-always-active/logger"
+)
+
+// Connection is the connection a Supervisor keeps up. *SlackWebSocket
+// implements it. ConnectContext gives up when ctx is cancelled.
+// ReadMessages returns nil when the connection was closed on purpose, and
+// an error when it failed.
+type Connection interface {
+	ConnectContext(ctx context.Context) error
+	ReadMessages() error
+	Disconnect()
+}
+
+// Backoff controls how quickly a Supervisor retries failed connections.
+type Backoff struct {
+	// Initial is the longest wait after the first failure. It grows by
+	// Multiplier with every further failure, up to Max. The actual wait is
+	// a random time below that ("full jitter"), so that many clients do not
+	// retry in step.
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	// After Threshold failures in a row the circuit opens: the failure is
+	// reported and further attempts are made only every Probe.
+	Threshold int
+	Probe     time.Duration
+}
+
+// DefaultBackoff retries after up to a second at first, then backs off to
+// at most two minutes, and after ten failures in a row probes every ten
+// minutes.
+var DefaultBackoff = Backoff{
+	Initial:    time.Second,
+	Max:        2 * time.Minute,
+	Multiplier: 2,
+	Threshold:  10,
+	Probe:      10 * time.Minute,
+}
+
+// delay returns how long to wait after the given number of consecutive
+// failures.
+func (b Backoff) delay(failures int, r *rand.Rand) time.Duration {
+	if b.Threshold > 0 && failures >= b.Threshold {
+		return b.Probe
+	}
+
+	limit := float64(b.Initial)
+	for i := 1; i < failures && limit < float64(b.Max); i++ {
+		limit *= b.Multiplier
+	}
+	if limit > float64(b.Max) {
+		limit = float64(b.Max)
+	}
+	if limit < 1 {
+		return 0
+	}
+	return time.Duration(r.Int63n(int64(limit)) + 1)
+}
+
+// stableAfter is how long a connection has to stay up before a drop no
+// longer counts as a failure.
+const stableAfter = time.Minute
+
+// Supervisor keeps a connection up while Run runs, or from Connect until
+// Disconnect. When a connection attempt fails or an established connection
+// drops, it dials again after a backoff delay.
+type Supervisor struct {
+	conn    Connection
+	backoff Backoff
+	clock   clock.Clock
+	rand    *rand.Rand
+
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	done     chan struct{}
+	failures int
+	// upSince is when the current connection was made, or zero while there
+	// is none
+	upSince time.Time
+}
+
+// NewSupervisor returns a Supervisor for conn. It does not connect until
+// Connect is called.
+func NewSupervisor(conn Connection, backoff Backoff) *Supervisor {
+	return &Supervisor{
+		conn:    conn,
+		backoff: backoff,
+		clock:   clock.Real,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// SetClock replaces the clock used for the backoff delays.
+func (s *Supervisor) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// Connect starts keeping the connection up in the background, as Run
+// does, until Disconnect is called. Failures are logged and retried rather
+// than returned, so Connect always returns nil.
+func (s *Supervisor) Connect() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.cancel, s.done = cancel, done
+
+	go func() {
+		defer close(done)
+		s.Run(ctx)
+	}()
+	return nil
+}
+
+// Disconnect closes the connection and stops retrying. An attempt in
+// progress is given up.
+func (s *Supervisor) Disconnect() {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel, s.done = nil, nil
+	s.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	<-done
+}
+
+// IsConnected reports whether the supervisor keeps the connection up. It
+// stays true while a dropped connection is being dialed again.
+func (s *Supervisor) IsConnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cancel != nil
+}
+
+// CircuitOpen reports whether so many attempts failed in a row that the
+// supervisor only probes at the slow rate. It closes again once a
+// connection stays up.
+func (s *Supervisor) CircuitOpen() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.upSince.IsZero() && s.clock.Since(s.upSince) >= stableAfter {
+		return false
+	}
+	return s.backoff.Threshold > 0 && s.failures >= s.backoff.Threshold
+}
+
+// Run reads from the connection while it is up and dials again with
+// backoff when it is not, until ctx is cancelled. Then it closes the
+// connection and returns ctx.Err(). Do not use it together with Connect.
+func (s *Supervisor) Run(ctx context.Context) error {
+	// Closing the connection ends a dial or read in progress
+	stop := context.AfterFunc(ctx, s.conn.Disconnect)
+	defer stop()
+	defer s.conn.Disconnect()
+
+	connected := s.attempt(ctx)
+	for {
+		if connected {
+			s.mu.Lock()
+			s.upSince = s.clock.Now()
+			s.mu.Unlock()
+
+			err := s.conn.ReadMessages()
+
+			// Once a connection stayed up, earlier failures no longer
+			// count, however it ended
+			s.mu.Lock()
+			stable := s.clock.Since(s.upSince) >= stableAfter
+			s.upSince = time.Time{}
+			if stable {
+				s.failures = 0
+			}
+			s.mu.Unlock()
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err == nil {
+				// Closed on purpose but still wanted, so connect again
+				// right away
+				connected = s.attempt(ctx)
+				continue
+			}
+			logger.Warn("Connection to Slack lost: %v", err)
+			s.conn.Disconnect()
+
+			// A connection that drops right away is as good as a failed one
+			if !stable {
+				s.mu.Lock()
+				s.failures++
+				s.mu.Unlock()
+			}
+		}
+
+		s.mu.Lock()
+		delay := s.backoff.delay(s.failures, s.rand)
+		s.mu.Unlock()
+
+		timer := s.clock.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		}
+		connected = s.attempt(ctx)
+	}
+}
+
+// attempt dials once and records the outcome. An attempt given up because
+// ctx was cancelled is not a failure.
+func (s *Supervisor) attempt(ctx context.Context) bool {
+	err := s.conn.ConnectContext(ctx)
+	if ctx.Err() != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		if s.backoff.Threshold > 0 && s.failures >= s.backoff.Threshold {
+			logger.Info("Connection to Slack restored after %d failed attempts", s.failures)
+		}
+		return true
+	}
+
+	s.failures++
+	switch {
+	case s.backoff.Threshold > 0 && s.failures == s.backoff.Threshold:
+		logger.Error("ALERT: connecting to Slack failed %d times in a row: %v. Trying again every %s", s.failures, err, s.backoff.Probe)
+	case s.backoff.Threshold > 0 && s.failures > s.backoff.Threshold:
+		logger.Warn("Connecting to Slack failed again: %v", err)
+	default:
+		logger.Error("Failed to connect to Slack (attempt %d): %v", s.failures, err)
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+package slackws
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lucy/slack-always-active/clock"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{
+		Initial:    time.Second,
+		Max:        10 * time.Second,
+		Multiplier: 2,
+		Threshold:  6,
+		Probe:      time.Hour,
+	}
+	r := rand.New(rand.NewSource(1))
+
+	tests := []struct {
+		failures int
+		limit    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if d := b.delay(tt.failures, r); d <= 0 || d > tt.limit {
+				t.Fatalf("delay(%d) = %s, want within (0, %s]", tt.failures, d, tt.limit)
+			}
+		}
+	}
+
+	for _, failures := range []int{6, 7, 100} {
+		if d := b.delay(failures, r); d != time.Hour {
+			t.Errorf("delay(%d) = %s, want the probe interval", failures, d)
+		}
+	}
+}
+
+// fakeConn fails the first few connection attempts and then stays up until
+// it is dropped or disconnected.
+type fakeConn struct {
+	clk      clock.Clock
+	mu       sync.Mutex
+	failures int
+	attempts chan time.Time
+	reads    chan struct{}
+	closed   chan struct{}
+	// planned makes the next read end as a planned close
+	planned bool
+}
+
+func newFakeConn(clk clock.Clock, failures int) *fakeConn {
+	return &fakeConn{clk: clk, failures: failures, attempts: make(chan time.Time, 100), reads: make(chan struct{}, 100)}
+}
+
+func (c *fakeConn) ConnectContext(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attempts <- c.clk.Now()
+	if c.failures > 0 {
+		c.failures--
+		return errors.New("connection refused")
+	}
+	c.closed = make(chan struct{})
+	return nil
+}
+
+func (c *fakeConn) ReadMessages() error {
+	c.reads <- struct{}{}
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed != nil {
+		<-closed
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.planned {
+		c.planned = false
+		return nil
+	}
+	return errors.New("websocket connection is closed")
+}
+
+// closePlanned ends the connection as a planned close, and makes the next
+// failures attempts fail.
+func (c *fakeConn) closePlanned(failures int) {
+	c.mu.Lock()
+	c.planned = true
+	c.failures = failures
+	c.mu.Unlock()
+	c.Disconnect()
+}
+
+func (c *fakeConn) Disconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed != nil {
+		close(c.closed)
+		c.closed = nil
+	}
+}
+
+// waitAttempt waits for the next connection attempt.
+func waitAttempt(t *testing.T, conn *fakeConn) time.Time {
+	t.Helper()
+	select {
+	case at := <-conn.attempts:
+		return at
+	case <-time.After(5 * time.Second):
+		t.Fatal("no connection attempt")
+		return time.Time{}
+	}
+}
+
+func TestSupervisorBacksOff(t *testing.T) {
+	start := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	conn := newFakeConn(clk, 4)
+	backoff := Backoff{
+		Initial:    time.Second,
+		Max:        time.Minute,
+		Multiplier: 2,
+		Threshold:  3,
+		Probe:      10 * time.Minute,
+	}
+	s := NewSupervisor(conn, backoff)
+	s.SetClock(clk)
+
+	s.Connect()
+	defer s.Disconnect()
+	last := waitAttempt(t, conn)
+	if !s.IsConnected() {
+		t.Error("IsConnected() = false while retrying")
+	}
+
+	// Each retry waits no longer than the growing limit, then the probe
+	// interval once the circuit is open
+	for i, limit := range []time.Duration{time.Second, 2 * time.Second, 10 * time.Minute, 10 * time.Minute} {
+		clk.BlockUntil(1)
+		clk.Advance(limit)
+		at := waitAttempt(t, conn)
+		if wait := at.Sub(last); wait <= 0 || wait > limit {
+			t.Errorf("retry %d after %s, want within (0, %s]", i+1, wait, limit)
+		}
+		if i >= 2 && !s.CircuitOpen() {
+			t.Errorf("retry %d: circuit closed after %d failures", i+1, i+2)
+		}
+		last = at
+	}
+
+	// The last probe connected; no more attempts
+	select {
+	case at := <-conn.attempts:
+		t.Errorf("unexpected attempt at %s", at)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSupervisorReconnectsAfterDrop(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC))
+	conn := newFakeConn(clk, 0)
+	s := NewSupervisor(conn, DefaultBackoff)
+	s.SetClock(clk)
+
+	s.Connect()
+	waitAttempt(t, conn)
+
+	// A connection that stayed up is dialed again within the initial delay
+	clk.Advance(time.Hour)
+	conn.Disconnect()
+	clk.BlockUntil(1)
+	clk.Advance(DefaultBackoff.Initial)
+	waitAttempt(t, conn)
+
+	s.Disconnect()
+	if s.IsConnected() {
+		t.Error("IsConnected() = true after Disconnect")
+	}
+	clk.Advance(time.Hour)
+	select {
+	case at := <-conn.attempts:
+		t.Errorf("attempt at %s after Disconnect", at)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSupervisorClosesCircuitAfterStableConnection(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC))
+	conn := newFakeConn(clk, 2)
+	backoff := Backoff{
+		Initial:    time.Second,
+		Max:        time.Minute,
+		Multiplier: 2,
+		Threshold:  2,
+		Probe:      10 * time.Minute,
+	}
+	s := NewSupervisor(conn, backoff)
+	s.SetClock(clk)
+
+	// Two failures open the circuit, the probe connects
+	s.Connect()
+	defer s.Disconnect()
+	waitAttempt(t, conn)
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	waitAttempt(t, conn)
+	if !s.CircuitOpen() {
+		t.Fatal("circuit closed after two failures")
+	}
+	clk.BlockUntil(1)
+	clk.Advance(10 * time.Minute)
+	waitAttempt(t, conn)
+	<-conn.reads
+
+	// The connection stays up, which closes the circuit
+	clk.Advance(stableAfter)
+	if s.CircuitOpen() {
+		t.Error("circuit open while the connection is stable")
+	}
+
+	// A planned close is followed by a failure, which backs off from the
+	// start again
+	conn.closePlanned(1)
+	last := waitAttempt(t, conn)
+	clk.BlockUntil(1)
+	if s.CircuitOpen() {
+		t.Error("circuit open after one failure")
+	}
+	clk.Advance(backoff.Initial)
+	if at := waitAttempt(t, conn); at.Sub(last) > backoff.Initial {
+		t.Errorf("retry after %s, want within %s", at.Sub(last), backoff.Initial)
+	}
+}
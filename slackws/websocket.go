@@ -1,6 +1,7 @@
 package slackws
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/lucy/slack-always-active/cache"
+	"github.com/lucy/slack-always-active/clock"
 	"github.com/lucy/slack-always-active/logger"
 )
 
@@ -28,47 +30,412 @@ type PongMessage struct {
 	ID   int    `json:"reply_to"`
 }
 
+// Endpoint names the URL a connection was made to.
+type Endpoint string
+
+const (
+	// EndpointCached is the reconnect URL Slack sent on an earlier
+	// connection.
+	EndpointCached Endpoint = "cached reconnect URL"
+	// EndpointPrimary is the default Slack WebSocket URL.
+	EndpointPrimary Endpoint = "primary endpoint"
+)
+
+// primaryURL is the default Slack WebSocket URL.
+const primaryURL = "wss://wss-primary.slack.com/"
+
+// maxReconnectURLAge is how long a cached reconnect URL is tried before it
+// is considered stale.
+const maxReconnectURLAge = 10 * time.Minute
+
+// Defaults for detecting a dead connection: it is given up after
+// maxMissedPongs pings in a row go unanswered, or when a ping is not
+// answered within pongTimeout. Nothing at all received for pongTimeout
+// also ends the connection.
+const (
+	pingInterval   = 5 * time.Second
+	maxMissedPongs = 3
+	pongTimeout    = 30 * time.Second
+)
+
+// rotationInterval is how often a connection is replaced by a fresh one by
+// default.
+const rotationInterval = 5 * time.Minute
+
+// helloTimeout is how long Slack may take by default to say hello on a new
+// connection. Until it does, the connection is not live.
+const helloTimeout = 30 * time.Second
+
 type SlackWebSocket struct {
-	conn        *websocket.Conn
-	token       string
-	cookie      string
-	pingID      int
-	lastPingID  int
-	mu          sync.Mutex
-	stopChan    chan struct{}
-	closed      bool
-	isConnected bool
-	cache       *cache.Cache
+	token      string
+	cookie     string
+	primaryURL string
+	cache      *cache.Cache
+	clock      clock.Clock
+
+	mu           sync.Mutex
+	state        State
+	conn         *connection
+	endpoint     Endpoint
+	maxMissed    int
+	pongTimeout  time.Duration
+	helloTimeout time.Duration
+	rotation     time.Duration
+	// dials counts Connect calls, so a Connect can tell whether the state
+	// it set is still its own. cancelDial gives up the dial in progress.
+	dials      int
+	cancelDial context.CancelFunc
+	observers  []func(StateChange)
+	// changes holds the transitions the observers were not told about yet.
+	// notifyMu keeps them in order.
+	changes  []StateChange
+	notifyMu sync.Mutex
+
+	// Handlers registered with OnMessage and the like
+	*Dispatcher
+}
+
+// connection is one WebSocket connection. The goroutine that calls
+// ReadMessages owns it: from then on only that goroutine writes to it and
+// changes its state.
+type connection struct {
+	ws *websocket.Conn
+	// owned is set, under SlackWebSocket.mu, once a goroutine took charge,
+	// and stopping once Disconnect asked it to close the connection
+	owned    bool
+	stopping bool
+	// stop asks the owner to close the connection; done is closed when the
+	// owner is finished with it
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	// The rest is only used by the owner. pings holds the time each
+	// unanswered ping was sent, by ID.
+	pingID int
+	pings  map[int]time.Time
+}
+
+// frame is a message read from a connection, or the error that ended it.
+type frame struct {
+	message []byte
+	err     error
+}
+
+// replacement is a connection dialed to replace the current one along with
+// its hello message, or the error that prevented it.
+type replacement struct {
+	ws       *websocket.Conn
+	endpoint Endpoint
+	hello    []byte
+	err      error
 }
 
 func NewSlackWebSocket(token, cookie string, cache *cache.Cache) *SlackWebSocket {
 	return &SlackWebSocket{
-		token:       token,
-		cookie:      cookie,
-		pingID:      1,
-		stopChan:    make(chan struct{}),
-		closed:      false,
-		isConnected: false,
-		cache:       cache,
+		token:        token,
+		cookie:       cookie,
+		primaryURL:   primaryURL,
+		cache:        cache,
+		clock:        clock.Real,
+		state:        Idle,
+		maxMissed:    maxMissedPongs,
+		pongTimeout:  pongTimeout,
+		helloTimeout: helloTimeout,
+		rotation:     rotationInterval,
+		Dispatcher:   NewDispatcher(),
 	}
 }
 
-func (s *SlackWebSocket) Connect() error {
+// SetClock replaces the clock used for pings and scheduled reconnection.
+func (s *SlackWebSocket) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// SetPongTimeout sets when a connection is considered dead: after missed
+// pings in a row go unanswered, or when a ping is not answered or nothing
+// is received within timeout. Zero values keep the defaults.
+func (s *SlackWebSocket) SetPongTimeout(missed int, timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if missed > 0 {
+		s.maxMissed = missed
+	}
+	if timeout > 0 {
+		s.pongTimeout = timeout
+	}
+}
+
+// SetHelloTimeout sets how long Slack may take to say hello on a new
+// connection. Zero keeps the default.
+func (s *SlackWebSocket) SetHelloTimeout(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d > 0 {
+		s.helloTimeout = d
+	}
+}
+
+// SetRotationInterval sets how often the connection is replaced by a fresh
+// one. Zero disables rotation.
+func (s *SlackWebSocket) SetRotationInterval(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotation = d
+}
+
+// State returns the state of the connection.
+func (s *SlackWebSocket) State() State {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.state
+}
+
+// OnStateChange registers fn to be called on every state transition, in
+// order. It is called without locks held, but must not call Connect,
+// Disconnect or Close.
+func (s *SlackWebSocket) OnStateChange(fn func(StateChange)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observers = append(s.observers, fn)
+}
+
+// setStateLocked moves to a new state; s.mu must be held. The observers
+// are told by the next call to notify.
+func (s *SlackWebSocket) setStateLocked(to State) {
+	from := s.state
+	if !canTransition(from, to) {
+		logger.Error("Invalid WebSocket state change from %s to %s", from, to)
+	}
+	s.state = to
+	s.changes = append(s.changes, StateChange{From: from, To: to, At: s.clock.Now()})
+}
 
-	// Reset state for new connection
-	s.pingID = 1
-	s.lastPingID = 0
-	s.closed = false
-	s.isConnected = false
-	s.stopChan = make(chan struct{})
+// notify passes the pending transitions to the observers. Call it after
+// releasing s.mu.
+func (s *SlackWebSocket) notify() {
+	s.notifyMu.Lock()
+	defer s.notifyMu.Unlock()
 
+	s.mu.Lock()
+	changes, observers := s.changes, s.observers
+	s.changes = nil
+	s.mu.Unlock()
+
+	for _, change := range changes {
+		for _, fn := range observers {
+			fn(change)
+		}
+	}
+}
+
+// transition moves c to a new state, unless it was replaced in the
+// meantime. A closed connection is forgotten.
+func (s *SlackWebSocket) transition(c *connection, to State) {
+	s.mu.Lock()
+	if s.conn != c {
+		s.mu.Unlock()
+		return
+	}
+	if to == Closed {
+		s.conn = nil
+	}
+	s.setStateLocked(to)
+	s.mu.Unlock()
+	s.notify()
+}
+
+// Run keeps a connection up until ctx is cancelled: it dials, reads and
+// pings the connection, replaces it on schedule and dials again with
+// DefaultBackoff when it fails. It returns ctx.Err() once the connection
+// is closed.
+func (s *SlackWebSocket) Run(ctx context.Context) error {
+	sup := NewSupervisor(s, DefaultBackoff)
+	sup.SetClock(s.clock)
+	return sup.Run(ctx)
+}
+
+// Connect opens a new connection, closing the current one first, and waits
+// for Slack to say hello on it.
+func (s *SlackWebSocket) Connect() error {
+	return s.ConnectContext(context.Background())
+}
+
+// ConnectContext is Connect, but gives up dialing and waiting for hello
+// when ctx is cancelled.
+func (s *SlackWebSocket) ConnectContext(ctx context.Context) error {
+	s.Disconnect()
+
+	s.mu.Lock()
+	if s.state != Idle && s.state != Closed {
+		state := s.state
+		s.mu.Unlock()
+		return fmt.Errorf("websocket connection is %s", state)
+	}
+	s.dials++
+	dial := s.dials
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for {
+		retry, err := s.connect(ctx, cancel, dial)
+		if !retry {
+			return err
+		}
+	}
+}
+
+// connect dials once for ConnectContext and waits for hello. It reports
+// whether the cached reconnect URL gave no hello, so the dial should be
+// repeated on the primary endpoint.
+func (s *SlackWebSocket) connect(ctx context.Context, cancel context.CancelFunc, dial int) (bool, error) {
+	s.mu.Lock()
+	if s.dials != dial || (s.state != Idle && s.state != Closed) {
+		s.mu.Unlock()
+		return false, fmt.Errorf("websocket connection was closed while waiting for hello")
+	}
+	s.cancelDial = cancel
+	s.setStateLocked(Dialing)
+	s.mu.Unlock()
+	s.notify()
+
+	ws, endpoint, err := s.open(ctx)
+
+	s.mu.Lock()
+	if s.dials == dial {
+		s.cancelDial = nil
+	}
+	if s.state != Dialing || s.dials != dial {
+		// Disconnect was called while dialing
+		s.mu.Unlock()
+		if ws != nil {
+			ws.Close()
+		}
+		return false, fmt.Errorf("websocket connection was closed while dialing")
+	}
+	if err != nil {
+		s.setStateLocked(Closed)
+		s.mu.Unlock()
+		s.notify()
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		return false, err
+	}
+	c := &connection{
+		ws:     ws,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		pingID: 1,
+		pings:  make(map[int]time.Time),
+	}
+	s.conn = c
+	s.endpoint = endpoint
+	timeout := s.helloTimeout
+	s.setStateLocked(AwaitingHello)
+	s.mu.Unlock()
+	s.notify()
+
+	logger.Info("Connected to Slack using the %s, waiting for hello", endpoint)
+
+	// Disconnect closes the connection, which ends the wait
+	hello, err := awaitHello(ctx, ws, timeout)
+	if err != nil {
+		s.drop(c)
+		s.mu.Lock()
+		stopping := c.stopping
+		s.mu.Unlock()
+		return !stopping && s.retryPrimary(ctx, endpoint, err), err
+	}
+	s.handle(c, hello)
+	if s.State() != Live {
+		// Disconnect was called while waiting
+		return false, fmt.Errorf("websocket connection was closed while waiting for hello")
+	}
+	return false, nil
+}
+
+// openHello opens a connection like open and waits for its hello. A
+// cached reconnect URL that gives no hello is replaced by the primary
+// endpoint.
+func (s *SlackWebSocket) openHello(ctx context.Context, timeout time.Duration) (*websocket.Conn, Endpoint, []byte, error) {
+	for {
+		ws, endpoint, err := s.open(ctx)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		hello, err := awaitHello(ctx, ws, timeout)
+		if err == nil {
+			return ws, endpoint, hello, nil
+		}
+		ws.Close()
+		if !s.retryPrimary(ctx, endpoint, err) {
+			return nil, "", nil, err
+		}
+	}
+}
+
+// retryPrimary reports whether a connection to endpoint that ended with
+// err before hello should be dialed again on the primary endpoint. An
+// expired reconnect URL often still accepts the connection but never says
+// hello, so it is removed from the cache.
+func (s *SlackWebSocket) retryPrimary(ctx context.Context, endpoint Endpoint, err error) bool {
+	if endpoint != EndpointCached || ctx.Err() != nil {
+		return false
+	}
+	logger.Warn("No hello on the cached reconnect URL, falling back to the primary endpoint: %v", err)
+	s.cache.ClearWebSocketURL()
+	return true
+}
+
+// open dials the cached reconnect URL or, failing that, the primary
+// endpoint.
+func (s *SlackWebSocket) open(ctx context.Context) (*websocket.Conn, Endpoint, error) {
 	// notRequiredParams := "&sync_desync=1&slack_client=desktop&start_args=%3Fagent%3Dclient%26org_wide_aware%3Dtrue%26agent_version%3D1742552854%26eac_cache_ts%3Dtrue%26cache_ts%3D0%26name_tagging%3Dtrue%26only_self_subteams%3Dtrue%26connect_only%3Dtrue%26ms_latest%3Dtrue&no_query_on_subscribe=1&flannel=3&lazy_channels=1&gateway_server=T05N3TFM0RW-4&batch_presence_aware=1"
 
+	// Try the reconnect URL Slack sent last, which is faster to connect to
+	if url := s.cachedURL(); url != "" {
+		ws, err := s.dial(ctx, url)
+		if err == nil {
+			return ws, EndpointCached, nil
+		}
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+		logger.Warn("Cached reconnect URL failed, falling back to the primary endpoint: %v", err)
+		s.cache.ClearWebSocketURL()
+	}
+
 	// Use default WebSocket URL
-	url := fmt.Sprintf("wss://wss-primary.slack.com/?token=%s", s.token)
+	url := fmt.Sprintf("%s?token=%s", s.primaryURL, s.token)
+	ws, err := s.dial(ctx, url)
+	if err != nil {
+		return nil, "", fmt.Errorf("error connecting to websocket: %v", err)
+	}
+	return ws, EndpointPrimary, nil
+}
 
+// cachedURL returns the cached reconnect URL, or an empty string if there
+// is none or it is too old to use. Old entries are removed from the cache.
+func (s *SlackWebSocket) cachedURL() string {
+	if s.cache == nil {
+		return ""
+	}
+	url, saved := s.cache.GetWebSocketURL()
+	if url == "" {
+		return ""
+	}
+	if age := s.clock.Since(saved); age > maxReconnectURLAge {
+		logger.Info("Discarding the cached reconnect URL, it is %s old", age.Round(time.Second))
+		s.cache.ClearWebSocketURL()
+		return ""
+	}
+	return url
+}
+
+// dial opens a WebSocket connection to url with the session cookie.
+func (s *SlackWebSocket) dial(ctx context.Context, url string) (*websocket.Conn, error) {
 	// Create custom dialer with cookie header
 	dialer := websocket.Dialer{
 		EnableCompression: true,
@@ -81,236 +448,447 @@ func (s *SlackWebSocket) Connect() error {
 	headers.Add("Cookie", s.cookie)
 
 	// Connect with custom headers
-	conn, _, err := dialer.Dial(url, headers)
-	if err != nil {
-		return fmt.Errorf("error connecting to websocket: %v", err)
-	}
-
-	s.conn = conn
-	s.isConnected = true
-	return nil
+	conn, _, err := dialer.DialContext(ctx, url, headers)
+	return conn, err
 }
 
-func (s *SlackWebSocket) Close() {
+// Endpoint returns which URL the current or last connection was made to.
+func (s *SlackWebSocket) Endpoint() Endpoint {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.endpoint
+}
 
-	if s.closed {
-		return
-	}
-
-	// Signal all goroutines to stop
-	close(s.stopChan)
-	s.closed = true
-	s.isConnected = false
-
-	// Close the WebSocket connection
-	if s.conn != nil {
-		s.conn.Close()
-		s.conn = nil
-	}
+// Close closes the connection; it is the same as Disconnect.
+func (s *SlackWebSocket) Close() {
+	s.Disconnect()
 }
 
+// IsConnected reports whether a connection is live.
 func (s *SlackWebSocket) IsConnected() bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.isConnected
+	return s.state == Live
 }
 
-func (s *SlackWebSocket) sendPing() error {
+// ReadMessages takes charge of the current connection: it reads and
+// dispatches messages, sends pings and replaces the connection on schedule
+// or when Slack announces that it closes it. It returns nil when the
+// connection was closed by Disconnect, and an error when it failed. Errors
+// Slack reported are returned as *SlackError.
+func (s *SlackWebSocket) ReadMessages() error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Check connection state
-	if s.conn == nil || s.closed || !s.isConnected {
+	c := s.conn
+	if c == nil {
+		s.mu.Unlock()
 		return fmt.Errorf("websocket connection is closed")
 	}
-
-	s.lastPingID = s.pingID
-	ping := PingMessage{
-		Type: "ping",
-		ID:   s.pingID,
+	if c.owned {
+		s.mu.Unlock()
+		return fmt.Errorf("websocket connection is already being read")
 	}
-
-	message, err := json.Marshal(ping)
-	if err != nil {
-		return fmt.Errorf("error marshaling ping message: %v", err)
+	if s.state == AwaitingHello {
+		s.mu.Unlock()
+		return fmt.Errorf("websocket connection is still waiting for hello")
 	}
+	c.owned = true
+	timeout, maxMissed, rotation := s.pongTimeout, s.maxMissed, s.rotation
+	s.mu.Unlock()
 
-	if err := s.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-		s.isConnected = false
-		return fmt.Errorf("error sending ping message: %v", err)
+	// A scheduled rotation hands over to the next connection
+	for {
+		next, err := s.own(c, timeout, maxMissed, rotation)
+		close(c.done)
+		if next == nil {
+			return err
+		}
+		c = next
 	}
-
-	// Increment ping ID for next ping
-	s.pingID++
-	return nil
 }
 
-func (s *SlackWebSocket) ReadMessages() error {
-	pingTicker := time.NewTicker(5 * time.Second)
-	reconnectTicker := time.NewTicker(5 * time.Minute)
-	defer pingTicker.Stop()
-	defer reconnectTicker.Stop()
-
-	// Start ping goroutine
+// own runs the connection until it ends, or until it was replaced by the
+// connection it returns. Reads happen on a separate goroutine, since they
+// block, but everything else happens here.
+func (s *SlackWebSocket) own(c *connection, timeout time.Duration, maxMissed int, rotation time.Duration) (*connection, error) {
+	frames := make(chan frame)
+	quit := make(chan struct{})
+	readerDone := make(chan struct{})
 	go func() {
+		defer close(readerDone)
 		for {
+			// Socket deadlines are checked by the OS, so they use the real
+			// time
+			c.ws.SetReadDeadline(time.Now().Add(timeout))
+			_, message, err := c.ws.ReadMessage()
 			select {
-			case <-s.stopChan:
+			case frames <- frame{message, err}:
+			case <-quit:
 				return
-			case <-pingTicker.C:
-				s.mu.Lock()
-				if s.conn == nil || s.closed || !s.isConnected {
-					s.mu.Unlock()
-					continue
-				}
-				s.mu.Unlock()
-
-				if err := s.sendPing(); err != nil {
-					if !strings.Contains(err.Error(), "close sent") {
-						logger.Error("Error sending ping: %v", err)
-					}
-					return
-				}
 			}
-		}
-	}()
-
-	// Start reconnection goroutine
-	go func() {
-		for {
-			select {
-			case <-s.stopChan:
+			if err != nil {
 				return
-			case <-reconnectTicker.C:
-				s.mu.Lock()
-				if s.isConnected && s.conn != nil {
-					logger.Info("Scheduled reconnection triggered")
-					// Store connection reference
-					conn := s.conn
-					s.mu.Unlock()
-
-					// Active closure: Send close message
-					if err := conn.WriteControl(websocket.CloseMessage,
-						websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
-						time.Now().Add(time.Second)); err != nil {
-						logger.Error("Error sending close message: %v", err)
-					}
-
-					// Close the connection
-					conn.Close()
-
-					// Update connection state
-					s.mu.Lock()
-					s.conn = nil
-					s.isConnected = false
-					s.closed = true
-					s.mu.Unlock()
-
-					// Attempt to reconnect
-					if err := s.Connect(); err != nil {
-						logger.Error("Error during scheduled reconnection: %v", err)
-						continue
-					}
-					logger.Info("Successfully reconnected")
-				} else {
-					s.mu.Unlock()
-				}
 			}
 		}
 	}()
+	// Every way out closes the connection, which ends the read
+	defer func() {
+		close(quit)
+		<-readerDone
+	}()
+
+	pingTicker := s.clock.NewTicker(pingInterval)
+	defer pingTicker.Stop()
+	var rotate <-chan time.Time
+	if rotation > 0 {
+		rotationTicker := s.clock.NewTicker(rotation)
+		defer rotationTicker.Stop()
+		rotate = rotationTicker.C()
+	}
+
+	// A replacement is dialed while this connection stays up, and given up
+	// if this one ends first
+	replaced := make(chan replacement)
+	replaceCtx, cancelReplace := context.WithCancel(context.Background())
+	defer cancelReplace()
+	replacing := false
+	startReplace := func() {
+		if !replacing {
+			replacing = true
+			go s.replace(replaceCtx, replaced, s.currentHelloTimeout())
+		}
+	}
+	// leaving is set once Slack announced that it closes this connection,
+	// and gone once it did
+	leaving, gone := false, false
 
-	// Read messages
 	for {
 		select {
-		case <-s.stopChan:
-			return nil
-		default:
-			s.mu.Lock()
-			if s.conn == nil || s.closed || !s.isConnected {
-				s.mu.Unlock()
-				return fmt.Errorf("websocket connection is closed")
+		case <-c.stop:
+			if gone {
+				s.drop(c)
+			} else {
+				s.drain(c)
 			}
-			conn := s.conn
-			s.mu.Unlock()
-
-			_, message, err := conn.ReadMessage()
-			if err != nil && !s.isConnected {
-				s.mu.Lock()
-				s.isConnected = false
-				if s.conn != nil {
-					// Check if it's a close error
-					if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
-						logger.Info("Received close message from peer")
-					} else if strings.Contains(err.Error(), "read tcp") {
-					} else {
-						logger.Error("Error reading message: %v", err)
-					}
-					s.conn.Close()
-					s.conn = nil
-				}
-				s.mu.Unlock()
-				return fmt.Errorf("error reading message: %v", err)
+			return nil, nil
+		case <-rotate:
+			if !replacing {
+				logger.Info("Scheduled reconnection triggered")
+				startReplace()
 			}
-
-			// Try to parse as pong message
-			var pongMsg PongMessage
-			if err := json.Unmarshal(message, &pongMsg); err == nil && pongMsg.Type == "pong" {
-				if pongMsg.ID == s.lastPingID {
-					// logger.Debug("Received matching pong with ID: %d", pongMsg.ID)
+		case r := <-replaced:
+			replacing = false
+			if r.err != nil && leaving {
+				s.drop(c)
+				return nil, fmt.Errorf("reconnecting after Slack closed the connection: %v", r.err)
+			}
+			if r.err != nil {
+				logger.Warn("Scheduled reconnection failed, keeping the current connection: %v", r.err)
+				continue
+			}
+			if next := s.handOver(c, r); next != nil {
+				// Only now that the new connection is live, close the old one
+				if gone {
+					c.ws.Close()
 				} else {
-					logger.Warn("Received pong with mismatched ID. Expected: %d, Got: %d", s.lastPingID, pongMsg.ID)
+					s.drain(c)
 				}
+				logger.Info("Successfully reconnected using the %s", r.endpoint)
+				// The hello handlers see the new connection's hello too
+				s.handle(next, r.hello)
+				return next, nil
+			}
+			r.ws.Close()
+		case <-pingTicker.C():
+			if gone {
 				continue
 			}
-
-			// Try to parse as reconnect message
-			var reconnectMsg ReconnectMessage
-			if err := json.Unmarshal(message, &reconnectMsg); err == nil && reconnectMsg.Type == "reconnect_url" {
-				// logger.Debug("Received new reconnect URL")
-				s.cache.SetWebSocketURL(reconnectMsg.URL)
+			// A half-open connection accepts pings but never answers them
+			if reason := c.unanswered(s.clock.Now(), maxMissed, timeout); reason != "" {
+				logger.Warn("Slack connection is dead, %s", reason)
+				s.drop(c)
+				return nil, fmt.Errorf("connection is dead, %s", reason)
+			}
+			if err := s.sendPing(c); err != nil {
+				s.drop(c)
+				return nil, err
+			}
+		case f := <-frames:
+			if f.err != nil && leaving {
+				// Slack closed the connection as announced; the
+				// replacement is on its way
+				gone = true
+				frames = nil
 				continue
 			}
+			if f.err != nil {
+				// Check if it's a close error
+				if websocket.IsCloseError(f.err, websocket.CloseNormalClosure) {
+					logger.Info("Received close message from peer")
+				} else if !strings.Contains(f.err.Error(), "read tcp") {
+					logger.Error("Error reading message: %v", f.err)
+				}
+				s.drop(c)
+				return nil, fmt.Errorf("error reading message: %v", f.err)
+			}
+			reconnect, err := s.handle(c, f.message)
+			if err != nil {
+				s.drop(c)
+				return nil, err
+			}
+			if reconnect {
+				// Slack closes the connection soon, so replace it right
+				// away
+				leaving = true
+				startReplace()
+			}
+		}
+	}
+}
+
+// replace dials a connection to replace the current one and waits for its
+// hello. It sends the result to replaced, or closes the connection if ctx
+// was cancelled in the meantime.
+func (s *SlackWebSocket) replace(ctx context.Context, replaced chan<- replacement, timeout time.Duration) {
+	ws, endpoint, hello, err := s.openHello(ctx, timeout)
+
+	select {
+	case replaced <- replacement{ws: ws, endpoint: endpoint, hello: hello, err: err}:
+	case <-ctx.Done():
+		if err == nil {
+			ws.Close()
+		}
+	}
+}
 
-			// Try to parse as hello message
-			var helloMsg struct {
-				Type   string `json:"type"`
-				Region string `json:"region"`
-				HostID string `json:"host_id"`
-				Start  bool   `json:"start"`
+// awaitHello reads from ws until Slack says hello and returns the hello
+// message. Other messages before it are dropped, except for errors.
+// Cancelling ctx closes ws.
+func awaitHello(ctx context.Context, ws *websocket.Conn, timeout time.Duration) ([]byte, error) {
+	stop := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stop()
+
+	// Socket deadlines are checked by the OS, so they use the real time
+	ws.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
 			}
-			if err := json.Unmarshal(message, &helloMsg); err == nil && helloMsg.Type == "hello" {
-				logger.Info("Successfully connected to Slack (Region: %s, Host: %s)", helloMsg.Region, helloMsg.HostID)
-				continue
+			return nil, fmt.Errorf("no hello from Slack within %s: %v", timeout, err)
+		}
+		var env Envelope
+		if err := json.Unmarshal(message, &env); err != nil {
+			continue
+		}
+		switch env.Type {
+		case "hello":
+			if err := json.Unmarshal(message, new(HelloEvent)); err != nil {
+				return nil, fmt.Errorf("error decoding hello event: %v", err)
+			}
+			return message, nil
+		case "error":
+			var ev ErrorEvent
+			if err := json.Unmarshal(message, &ev); err != nil {
+				return nil, fmt.Errorf("error decoding error event: %v", err)
 			}
+			return nil, &ev.Error
+		}
+	}
+}
+
+// currentHelloTimeout returns how long a new connection may take to say
+// hello.
+func (s *SlackWebSocket) currentHelloTimeout() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.helloTimeout
+}
+
+// handOver makes the replacement r the current connection in place of c and
+// returns it, unless c was disconnected in the meantime.
+func (s *SlackWebSocket) handOver(c *connection, r replacement) *connection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != c || c.stopping {
+		return nil
+	}
+	next := &connection{
+		ws:     r.ws,
+		owned:  true,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		pingID: 1,
+		pings:  make(map[int]time.Time),
+	}
+	s.conn = next
+	s.endpoint = r.endpoint
+	return next
+}
+
+// drain closes c gracefully.
+func (s *SlackWebSocket) drain(c *connection) {
+	s.transition(c, Draining)
+
+	// Active closure: Send close message. Socket deadlines are checked by
+	// the OS, so they use the real time.
+	if err := c.ws.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second)); err != nil {
+		logger.Warn("Error sending close message: %v", err)
+	}
+	c.ws.Close()
+	s.transition(c, Closed)
+}
 
-			// Print all other messages
-			var genericMsg map[string]interface{}
-			if err := json.Unmarshal(message, &genericMsg); err == nil {
-				// Skip ping messages
-				if msgType, ok := genericMsg["type"].(string); ok && (msgType == "ping" || msgType == "reconnect_url") {
-					continue
+// drop closes c after it failed.
+func (s *SlackWebSocket) drop(c *connection) {
+	c.ws.Close()
+	s.transition(c, Closed)
+}
+
+func (s *SlackWebSocket) sendPing(c *connection) error {
+	ping := PingMessage{
+		Type: "ping",
+		ID:   c.pingID,
+	}
+
+	message, err := json.Marshal(ping)
+	if err != nil {
+		return fmt.Errorf("error marshaling ping message: %v", err)
+	}
+
+	if err := c.ws.WriteMessage(websocket.TextMessage, message); err != nil {
+		return fmt.Errorf("error sending ping message: %v", err)
+	}
+
+	// Increment ping ID for next ping
+	c.pings[c.pingID] = s.clock.Now()
+	c.pingID++
+	return nil
+}
+
+// unanswered reports why the connection looks dead from the pings that were
+// not answered, or returns an empty string if it does not.
+func (c *connection) unanswered(now time.Time, maxMissed int, timeout time.Duration) string {
+	if len(c.pings) == 0 {
+		return ""
+	}
+	if len(c.pings) >= maxMissed {
+		return fmt.Sprintf("%d pings in a row were not answered", len(c.pings))
+	}
+	for id, sent := range c.pings {
+		if age := now.Sub(sent); age >= timeout {
+			return fmt.Sprintf("ping %d was not answered within %s", id, timeout)
+		}
+	}
+	return ""
+}
+
+// handle decodes a message and passes it on. It reports whether Slack asked
+// for a reconnect, and returns the error Slack reported, if any. A hello,
+// goodbye or error that cannot be decoded is an error too.
+func (s *SlackWebSocket) handle(c *connection, message []byte) (bool, error) {
+	// Decode the type once, then the message as that type
+	var env Envelope
+	if err := json.Unmarshal(message, &env); err != nil {
+		if !s.dispatchRaw(RawEvent{Data: message}) {
+			logger.Info("Received raw message: %s", string(message))
+		}
+		return false, nil
+	}
+	ev, handled, err := s.dispatch(env.Type, message)
+	if err != nil {
+		switch env.Type {
+		case "hello", "goodbye", "error":
+			// What Slack meant for the connection is unknown, so end it
+			return false, err
+		}
+		logger.Warn("%v", err)
+		return false, nil
+	}
+
+	switch ev := ev.(type) {
+	case *PongMessage:
+		if _, ok := c.pings[ev.ID]; ok {
+			// Earlier pings are answered by this pong as well
+			for id := range c.pings {
+				if id <= ev.ID {
+					delete(c.pings, id)
 				}
-				// Pretty print the message
-				prettyJSON, _ := json.MarshalIndent(genericMsg, "", "  ")
-				logger.Info("Received message:\n%s", string(prettyJSON))
-			} else {
-				// If not JSON, print raw message
-				logger.Info("Received raw message: %s", string(message))
 			}
+		} else {
+			logger.Warn("Received pong with unknown ID. Expected: %d, Got: %d", c.pingID-1, ev.ID)
+		}
+	case *ReconnectMessage:
+		if s.cache != nil {
+			s.cache.SetWebSocketURL(ev.URL, s.clock.Now())
+		}
+	case *HelloEvent:
+		logger.Info("Successfully connected to Slack (Region: %s, Host: %s)", ev.Region, ev.HostID)
+		s.live(c)
+	case *GoodbyeEvent:
+		logger.Info("Slack said goodbye, replacing the connection")
+		return true, nil
+	case *TeamMigrationStartedEvent:
+		logger.Info("Workspace migration started, replacing the connection")
+		return true, nil
+	case *ErrorEvent:
+		logger.Error("Slack reported an error: %v", &ev.Error)
+		return false, &ev.Error
+	default:
+		// Other types that no handler took
+		if !handled && env.Type != "ping" {
+			logger.Info("Received unhandled %q message: %s", env.Type, string(message))
 		}
 	}
+	return false, nil
 }
 
-// Disconnect closes the WebSocket connection
-func (ws *SlackWebSocket) Disconnect() {
-	ws.mu.Lock()
-	defer ws.mu.Unlock()
+// live moves c to live, if it is still waiting for hello.
+func (s *SlackWebSocket) live(c *connection) {
+	s.mu.Lock()
+	if s.conn != c || s.state != AwaitingHello {
+		s.mu.Unlock()
+		return
+	}
+	s.setStateLocked(Live)
+	s.mu.Unlock()
+	s.notify()
+}
 
-	if ws.conn != nil {
-		ws.isConnected = false
-		ws.conn.UnderlyingConn().Close()
-		ws.conn = nil
+// Disconnect closes the WebSocket connection. If ReadMessages is running,
+// it waits for it to close the connection and return.
+func (s *SlackWebSocket) Disconnect() {
+	s.mu.Lock()
+	if s.state == Dialing {
+		// Connect closes the connection once the dial is given up
+		if s.cancelDial != nil {
+			s.cancelDial()
+			s.cancelDial = nil
+		}
+		s.setStateLocked(Closed)
+		s.mu.Unlock()
+		s.notify()
+		return
+	}
+	c := s.conn
+	if c == nil {
+		s.mu.Unlock()
+		return
 	}
+	owned := c.owned
+	c.owned = true
+	c.stopping = true
+	s.mu.Unlock()
+
+	if owned {
+		c.stopOnce.Do(func() { close(c.stop) })
+		<-c.done
+		return
+	}
+
+	// Nobody reads the connection yet, so close it here
+	s.drain(c)
+	close(c.done)
 }
@@ -0,0 +1,605 @@
+package slackws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lucy/slack-always-active/cache"
+	"github.com/lucy/slack-always-active/clock"
+)
+
+// newServer starts a WebSocket server that accepts connections on any path,
+// greets them with hello and returns its ws:// URL. If pong is set, it
+// answers pings.
+func newServer(t *testing.T, pong bool) string {
+	return newServerFunc(t, func(conn *websocket.Conn) {
+		conn.WriteJSON(HelloEvent{Type: "hello"})
+		for {
+			var ping PingMessage
+			if err := conn.ReadJSON(&ping); err != nil {
+				return
+			}
+			if pong && ping.Type == "ping" {
+				conn.WriteJSON(PongMessage{Type: "pong", ID: ping.ID})
+			}
+		}
+	})
+}
+
+// newServerFunc starts a WebSocket server that runs serve for every
+// connection and returns its ws:// URL.
+func newServerFunc(t *testing.T, serve func(conn *websocket.Conn)) string {
+	t.Helper()
+	upgrader := websocket.Upgrader{Subprotocols: []string{"slack"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func newTestSocket(t *testing.T, primary string) (*SlackWebSocket, *cache.Cache) {
+	t.Helper()
+	c, err := cache.NewCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSlackWebSocket("xoxc-token", "d=cookie", c)
+	s.primaryURL = primary
+	t.Cleanup(s.Disconnect)
+	return s, c
+}
+
+func TestConnectUsesCachedURL(t *testing.T) {
+	url := newServer(t, false)
+	s, c := newTestSocket(t, url+"/primary/")
+	c.SetWebSocketURL(url+"/cached?ticket=1", time.Now())
+
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Endpoint(); got != EndpointCached {
+		t.Errorf("Endpoint() = %q, want %q", got, EndpointCached)
+	}
+}
+
+func TestConnectFallsBackToPrimary(t *testing.T) {
+	url := newServer(t, false)
+
+	// The cached URL points to a server that is gone
+	gone := httptest.NewServer(http.NotFoundHandler())
+	goneURL := "ws" + strings.TrimPrefix(gone.URL, "http")
+	gone.Close()
+
+	s, c := newTestSocket(t, url+"/primary/")
+	c.SetWebSocketURL(goneURL+"/cached?ticket=1", time.Now())
+
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Endpoint(); got != EndpointPrimary {
+		t.Errorf("Endpoint() = %q, want %q", got, EndpointPrimary)
+	}
+	if cached, _ := c.GetWebSocketURL(); cached != "" {
+		t.Errorf("the failed URL %q is still cached", cached)
+	}
+}
+
+func TestConnectDiscardsStaleURL(t *testing.T) {
+	url := newServer(t, false)
+	s, c := newTestSocket(t, url+"/primary/")
+	clk := clock.NewFake(time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC))
+	s.SetClock(clk)
+	c.SetWebSocketURL(url+"/cached?ticket=1", clk.Now())
+	clk.Advance(maxReconnectURLAge + time.Minute)
+
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Endpoint(); got != EndpointPrimary {
+		t.Errorf("Endpoint() = %q, want %q", got, EndpointPrimary)
+	}
+	if cached, _ := c.GetWebSocketURL(); cached != "" {
+		t.Errorf("the stale URL %q is still cached", cached)
+	}
+}
+
+func TestConnectFallsBackWithoutHello(t *testing.T) {
+	tests := []struct {
+		name  string
+		serve func(conn *websocket.Conn)
+	}{
+		// An expired reconnect URL still accepts the connection
+		{"closed before hello", func(conn *websocket.Conn) {}},
+		{"error before hello", func(conn *websocket.Conn) {
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"error","error":{"code":1,"msg":"Socket URL has expired"}}`))
+			conn.ReadMessage()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, c := newTestSocket(t, newServer(t, false)+"/primary/")
+			c.SetWebSocketURL(newServerFunc(t, tt.serve)+"/cached?ticket=1", time.Now())
+
+			if err := s.Connect(); err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Endpoint(); got != EndpointPrimary {
+				t.Errorf("Endpoint() = %q, want %q", got, EndpointPrimary)
+			}
+			if cached, _ := c.GetWebSocketURL(); cached != "" {
+				t.Errorf("the expired URL %q is still cached", cached)
+			}
+		})
+	}
+}
+
+func TestReconnectURLSavedWithClock(t *testing.T) {
+	url := newScriptServer(t, `{"type":"hello"}`, `{"type":"reconnect_url","url":"wss://example.com/?ticket=2"}`)
+	s, c := newTestSocket(t, url+"/primary/")
+	clk := clock.NewFake(time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC))
+	s.SetClock(clk)
+
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	go s.ReadMessages()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cached, saved := c.GetWebSocketURL()
+		if cached != "" {
+			if !saved.Equal(clk.Now()) {
+				t.Errorf("URL saved at %s, want %s", saved, clk.Now())
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("reconnect URL was not cached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// readUntilDead runs ReadMessages and advances clk by the ping interval
+// until it returns, at most n times. It returns the error of ReadMessages,
+// or nil if it did not return.
+func readUntilDead(t *testing.T, s *SlackWebSocket, clk *clock.Fake, n int) error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ReadMessages()
+	}()
+
+	for i := 0; i < n; i++ {
+		// Wait for the ping and reconnect tickers
+		clk.BlockUntil(2)
+		clk.Advance(pingInterval)
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	s.Disconnect()
+	<-done
+	return nil
+}
+
+func TestMissedPongsEndConnection(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC))
+	s, _ := newTestSocket(t, newServer(t, false)+"/primary/")
+	s.SetClock(clk)
+	s.SetPongTimeout(3, time.Hour)
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := readUntilDead(t, s, clk, 10); err == nil {
+		t.Fatal("the connection stayed up without pongs")
+	}
+	if s.IsConnected() {
+		t.Error("IsConnected() = true after the connection was declared dead")
+	}
+}
+
+func TestPongDeadlineEndsConnection(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC))
+	s, _ := newTestSocket(t, newServer(t, false)+"/primary/")
+	s.SetClock(clk)
+	s.SetPongTimeout(100, 12*time.Second)
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first ping goes out after 5s and is overdue at 20s
+	if err := readUntilDead(t, s, clk, 10); err == nil {
+		t.Fatal("the connection stayed up without pongs")
+	}
+}
+
+func TestAnsweredPingsKeepConnection(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC))
+	s, _ := newTestSocket(t, newServer(t, true)+"/primary/")
+	s.SetClock(clk)
+	s.SetPongTimeout(2, 8*time.Second)
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := readUntilDead(t, s, clk, 10); err != nil {
+		t.Fatalf("the connection was dropped although pings were answered: %v", err)
+	}
+}
+
+func TestReadDeadlineEndsConnection(t *testing.T) {
+	// The fake clock never sends pings, so only the read deadline applies
+	clk := clock.NewFake(time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC))
+	s, _ := newTestSocket(t, newServer(t, false)+"/primary/")
+	s.SetClock(clk)
+	s.SetPongTimeout(0, 100*time.Millisecond)
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ReadMessages()
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "timeout") {
+			t.Errorf("ReadMessages() = %v, want a timeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the read deadline did not end the connection")
+	}
+}
+
+// connLog records when a server accepts and loses connections.
+type connLog struct {
+	mu     sync.Mutex
+	events []string
+	open   int
+}
+
+func (l *connLog) add(event string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *connLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.events, ", ")
+}
+
+// newLoggingServer is newServer with answered pings that logs connections.
+func newLoggingServer(t *testing.T) (string, *connLog) {
+	log := &connLog{}
+	url := newServerFunc(t, func(conn *websocket.Conn) {
+		log.mu.Lock()
+		log.open++
+		n := log.open
+		log.mu.Unlock()
+		log.add(fmt.Sprintf("open %d", n))
+		defer log.add(fmt.Sprintf("close %d", n))
+
+		conn.WriteJSON(HelloEvent{Type: "hello"})
+		for {
+			var ping PingMessage
+			if err := conn.ReadJSON(&ping); err != nil {
+				return
+			}
+			conn.WriteJSON(PongMessage{Type: "pong", ID: ping.ID})
+		}
+	})
+	return url, log
+}
+
+// waitLog waits until the server logged want.
+func waitLog(t *testing.T, log *connLog, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for log.String() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("server log = %q, want %q", log.String(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRotationMakesBeforeBreak(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC))
+	url, log := newLoggingServer(t)
+	s, _ := newTestSocket(t, url+"/primary/")
+	s.SetClock(clk)
+	s.SetRotationInterval(time.Minute)
+	r := record(t, s)
+
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ReadMessages()
+	}()
+	r.waitFor(Live)
+
+	// The new connection is open before the old one closes, and the state
+	// stays live throughout
+	clk.BlockUntil(2)
+	clk.Advance(time.Minute)
+	waitLog(t, log, "open 1, open 2, close 1")
+	if s.State() != Live {
+		t.Errorf("State() = %s after rotation, want live", s.State())
+	}
+	if got, want := r.states(), "dialing, awaiting hello, live"; got != want {
+		t.Errorf("states = %s, want %s", got, want)
+	}
+
+	// The new connection is owned like the first one
+	s.Disconnect()
+	if err := <-done; err != nil {
+		t.Errorf("ReadMessages() = %v after Disconnect, want nil", err)
+	}
+	waitLog(t, log, "open 1, open 2, close 1, close 2")
+}
+
+func TestRotationFallsBackWithoutHello(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC))
+	url, log := newLoggingServer(t)
+	s, c := newTestSocket(t, url+"/primary/")
+	s.SetClock(clk)
+	s.SetRotationInterval(time.Minute)
+
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ReadMessages()
+	}()
+
+	// The reconnect URL Slack sent has expired by the time it is used
+	expired := newServerFunc(t, func(conn *websocket.Conn) {})
+	c.SetWebSocketURL(expired+"/cached?ticket=1", clk.Now())
+	clk.BlockUntil(2)
+	clk.Advance(time.Minute)
+	waitLog(t, log, "open 1, open 2, close 1")
+	if got := s.Endpoint(); got != EndpointPrimary {
+		t.Errorf("Endpoint() = %q, want %q", got, EndpointPrimary)
+	}
+	if cached, _ := c.GetWebSocketURL(); cached != "" {
+		t.Errorf("the expired URL %q is still cached", cached)
+	}
+
+	s.Disconnect()
+	<-done
+}
+
+func TestRotationDisabled(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC))
+	url, log := newLoggingServer(t)
+	s, _ := newTestSocket(t, url+"/primary/")
+	s.SetClock(clk)
+	s.SetRotationInterval(0)
+	s.SetPongTimeout(100, time.Hour)
+
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ReadMessages()
+	}()
+
+	// Only the ping ticker is running
+	for i := 0; i < 10; i++ {
+		clk.BlockUntil(1)
+		clk.Advance(time.Minute)
+	}
+	s.Disconnect()
+	<-done
+	waitLog(t, log, "open 1, close 1")
+}
+
+// newScriptServer starts a server that sends messages on every connection
+// and then waits for it to be closed.
+func newScriptServer(t *testing.T, messages ...string) string {
+	return newServerFunc(t, func(conn *websocket.Conn) {
+		for _, message := range messages {
+			conn.WriteMessage(websocket.TextMessage, []byte(message))
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func TestHelloTimeout(t *testing.T) {
+	s, _ := newTestSocket(t, newScriptServer(t)+"/primary/")
+	s.SetHelloTimeout(100 * time.Millisecond)
+	r := record(t, s)
+
+	if err := s.Connect(); err == nil {
+		t.Fatal("Connect succeeded without hello")
+	}
+	if s.IsConnected() {
+		t.Error("IsConnected() = true without hello")
+	}
+	if got, want := r.states(), "dialing, awaiting hello, closed"; got != want {
+		t.Errorf("states = %s, want %s", got, want)
+	}
+}
+
+func TestGoodbyeReplacesConnection(t *testing.T) {
+	tests := []struct {
+		message string
+		// hangUp closes the first connection right after the message
+		hangUp bool
+		want   string
+	}{
+		{`{"type":"goodbye"}`, false, "open 1, open 2, close 1"},
+		{`{"type":"team_migration_started"}`, false, "open 1, open 2, close 1"},
+		{`{"type":"goodbye"}`, true, "open 1, close 1, open 2"},
+	}
+	for _, tt := range tests {
+		log := &connLog{}
+		url := newServerFunc(t, func(conn *websocket.Conn) {
+			log.mu.Lock()
+			log.open++
+			n := log.open
+			log.mu.Unlock()
+			log.add(fmt.Sprintf("open %d", n))
+			defer log.add(fmt.Sprintf("close %d", n))
+
+			conn.WriteJSON(HelloEvent{Type: "hello", HostID: fmt.Sprint(n)})
+			if n == 1 {
+				conn.WriteMessage(websocket.TextMessage, []byte(tt.message))
+				if tt.hangUp {
+					return
+				}
+			}
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		})
+		s, _ := newTestSocket(t, url+"/primary/")
+		r := record(t, s)
+		hellos := make(chan string, 10)
+		s.OnHello(func(ev *HelloEvent) { hellos <- ev.HostID })
+
+		if err := s.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- s.ReadMessages()
+		}()
+
+		// The new connection is made before the old one is let go, unless
+		// Slack hangs up first, and its hello reaches the handlers
+		waitLog(t, log, tt.want)
+		for _, want := range []string{"1", "2"} {
+			select {
+			case got := <-hellos:
+				if got != want {
+					t.Errorf("%s: hello from host %s, want %s", tt.message, got, want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: no hello from host %s", tt.message, want)
+			}
+		}
+		if got, want := r.states(), "dialing, awaiting hello, live"; got != want {
+			t.Errorf("%s: states = %s, want %s", tt.message, got, want)
+		}
+
+		s.Disconnect()
+		if err := <-done; err != nil {
+			t.Errorf("%s: ReadMessages() = %v after Disconnect, want nil", tt.message, err)
+		}
+	}
+}
+
+func TestSlackErrors(t *testing.T) {
+	const expired = `{"type":"error","error":{"code":1,"msg":"Socket URL has expired"}}`
+
+	// Before hello, Connect fails
+	s, _ := newTestSocket(t, newScriptServer(t, expired)+"/primary/")
+	err := s.Connect()
+	var slackErr *SlackError
+	if !errors.As(err, &slackErr) || slackErr.Code != 1 {
+		t.Errorf("Connect() = %v, want Slack error 1", err)
+	}
+
+	// After hello, the connection ends
+	s, _ = newTestSocket(t, newScriptServer(t, `{"type":"hello"}`, expired)+"/primary/")
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	err = s.ReadMessages()
+	if !errors.As(err, &slackErr) || slackErr.Code != 1 || slackErr.Msg != "Socket URL has expired" {
+		t.Errorf("ReadMessages() = %v, want Slack error 1", err)
+	}
+	if s.State() != Closed {
+		t.Errorf("State() = %s after an error, want closed", s.State())
+	}
+}
+
+func TestUndecodableEventsEndConnection(t *testing.T) {
+	// A hello that cannot be decoded is no hello
+	s, _ := newTestSocket(t, newScriptServer(t, `{"type":"hello","region":5}`)+"/primary/")
+	s.SetHelloTimeout(time.Second)
+	if err := s.Connect(); err == nil {
+		t.Error("Connect succeeded with an undecodable hello")
+	}
+	if s.State() != Closed {
+		t.Errorf("State() = %s after an undecodable hello, want closed", s.State())
+	}
+
+	// So is an error after it
+	s, _ = newTestSocket(t, newScriptServer(t, `{"type":"hello"}`, `{"type":"error","error":"Socket URL has expired"}`)+"/primary/")
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReadMessages(); err == nil || !strings.Contains(err.Error(), "decoding") {
+		t.Errorf("ReadMessages() = %v, want a decoding error", err)
+	}
+	if s.State() != Closed {
+		t.Errorf("State() = %s after an undecodable error, want closed", s.State())
+	}
+}
+
+func TestRunReturnsOnCancel(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		url   string
+		state State
+	}{
+		{"live", newServer(t, true), Live},
+		{"waiting for hello", newScriptServer(t), AwaitingHello},
+	} {
+		s, _ := newTestSocket(t, tt.url+"/primary/")
+		r := record(t, s)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- s.Run(ctx)
+		}()
+		r.waitFor(tt.state)
+
+		// Cancelling interrupts whatever Run is doing
+		cancel()
+		select {
+		case err := <-done:
+			if err != context.Canceled {
+				t.Errorf("%s: Run() = %v, want context.Canceled", tt.name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: Run did not return after cancel", tt.name)
+		}
+		if s.State() != Closed {
+			t.Errorf("%s: State() = %s after Run, want closed", tt.name, s.State())
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lucy/slack-always-active/clock"
+	"github.com/lucy/slack-always-active/logger"
+	"github.com/lucy/slack-always-active/schedule"
+	"github.com/lucy/slack-always-active/slackws"
+)
+
+const (
+	// jumpCheckInterval is how often the supervisor looks for jumps in the
+	// wall clock, and jumpThreshold how far the clock has to jump.
+	jumpCheckInterval = 10 * time.Second
+	jumpThreshold     = time.Minute
+)
+
+// session is the part of the Slack connection the supervisor manages.
+// IsConnected reports whether the connection is wanted; the session keeps
+// it up by itself and reports when it actually is live through
+// OnStateChange.
+type session interface {
+	Connect() error
+	Disconnect()
+	IsConnected() bool
+	OnStateChange(fn func(slackws.StateChange))
+}
+
+// slackSession runs the Slack connection while it is wanted. Connect
+// starts ws.Run under a context of its own and Disconnect cancels it,
+// which also gives up a dial in progress.
+type slackSession struct {
+	ws     *slackws.SlackWebSocket
+	parent context.Context
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newSlackSession returns a session for ws that stops when ctx is
+// cancelled.
+func newSlackSession(ctx context.Context, ws *slackws.SlackWebSocket) *slackSession {
+	return &slackSession{ws: ws, parent: ctx}
+}
+
+// Connect starts keeping the connection up. Failures are retried rather
+// than returned, so it always returns nil.
+func (s *slackSession) Connect() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(s.parent)
+	done := make(chan struct{})
+	s.cancel, s.done = cancel, done
+
+	go func() {
+		defer close(done)
+		s.ws.Run(ctx)
+	}()
+	return nil
+}
+
+// Disconnect stops the connection and waits until it is closed.
+func (s *slackSession) Disconnect() {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel, s.done = nil, nil
+	s.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	<-done
+}
+
+// IsConnected reports whether the connection is wanted.
+func (s *slackSession) IsConnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cancel != nil
+}
+
+func (s *slackSession) OnStateChange(fn func(slackws.StateChange)) {
+	s.ws.OnStateChange(fn)
+}
+
+// supervise connects the session when working hours start and disconnects
+// it when they end, until ctx is cancelled. Overrides take precedence over
+// the working hours. The guard keeps the time the connection is live
+// within the active-time limits. When the host resumes from sleep or the
+// wall clock jumps, it re-evaluates the schedule and dials a fresh
+// connection.
+func supervise(ctx context.Context, sched *schedule.Schedule, ws session, guard *schedule.Guard, clk clock.Clock) {
+	events := sched.Watch(ctx)
+
+	// Only the time the connection is live counts as active. The guard is
+	// told right away; changed wakes the loop to re-arm the guard timer.
+	var live atomic.Bool
+	changed := make(chan struct{}, 1)
+	ws.OnStateChange(func(change slackws.StateChange) {
+		var err error
+		switch {
+		case change.To == slackws.Live:
+			live.Store(true)
+			err = guard.Connected(change.At)
+		case change.From == slackws.Live:
+			live.Store(false)
+			err = guard.Disconnected(change.At)
+		default:
+			return
+		}
+		if err != nil {
+			logger.Error("%v", err)
+		}
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+
+	healthTicker := clk.NewTicker(time.Minute)
+	defer healthTicker.Stop()
+	working := false
+
+	// Timers only count time the host was awake, so look for jumps in the
+	// wall clock often
+	jumpTicker := clk.NewTicker(jumpCheckInterval)
+	defer jumpTicker.Stop()
+	jumps := clock.NewJumpDetector(jumpCheckInterval, jumpThreshold)
+	jumps.Check(clk.Now())
+
+	// The guard timer fires when the connection may reach an active-time
+	// limit, or when a forced break is over
+	var guardTimer clock.Timer
+	var guardWake <-chan time.Time
+	stopGuardTimer := func() {
+		if guardTimer != nil {
+			guardTimer.Stop()
+			guardTimer, guardWake = nil, nil
+		}
+	}
+	defer stopGuardTimer()
+	armGuardTimer := func() {
+		stopGuardTimer()
+		now := clk.Now()
+		var at time.Time
+		if live.Load() {
+			at = guard.Deadline(now)
+		} else if allowed, _, resume := guard.Check(now); working && !allowed {
+			at = resume
+		}
+		if !at.IsZero() {
+			guardTimer = clk.NewTimer(at.Sub(now))
+			guardWake = guardTimer.C()
+		}
+	}
+
+	connect := func(message string, args ...interface{}) {
+		if allowed, reason, resume := guard.Check(clk.Now()); !allowed {
+			logger.Info("Not connecting to Slack, %s. Connecting again at %s", reason, formatTimeInLocation(resume, sched.Location()))
+			return
+		}
+		logger.Info(message, args...)
+		if err := ws.Connect(); err != nil {
+			logger.Error("Failed to connect to Slack: %v", err)
+		}
+	}
+
+	// resumed handles a jump in the wall clock since the last check. The
+	// connection did not survive a sleep, and the schedule may have moved
+	// on, so it drops the connection and connects again if it is working
+	// time now. It reports whether there was a jump.
+	resumed := func() bool {
+		jump, ok := jumps.Check(clk.Now())
+		if !ok {
+			return false
+		}
+		logger.Warn("Clock jumped: %s passed since %s, %s of it awake. Re-evaluating the schedule", jump.Wall, formatTimeInLocation(jump.From, sched.Location()), jump.Monotonic)
+		// The time asleep was not active
+		if err := guard.Disconnected(jump.From); err != nil {
+			logger.Error("%v", err)
+		}
+		if ws.IsConnected() {
+			ws.Disconnect()
+		}
+		sched.Reevaluate()
+		working = sched.IsWorkingTimeAt(jump.To)
+		if working {
+			connect("Resumed during working hours, connecting to Slack...")
+		}
+		armGuardTimer()
+		return true
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-jumpTicker.C():
+			resumed()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if resumed() {
+				continue
+			}
+			working = event.Type == schedule.Start
+			override, overridden := sched.OverrideAt(event.Time)
+			if working {
+				if !ws.IsConnected() {
+					if overridden {
+						connect("Override active until %s, connecting to Slack...", formatTimeInLocation(override.Until, sched.Location()))
+					} else {
+						connect("Working hours started, connecting to Slack...")
+					}
+				}
+				armGuardTimer()
+				continue
+			}
+
+			// If we're outside working hours, disconnect WebSocket
+			if ws.IsConnected() {
+				if overridden {
+					logger.Info("Override away until %s, disconnecting from Slack...", formatTimeInLocation(override.Until, sched.Location()))
+				} else {
+					logger.Info("Working hours ended, disconnecting from Slack...")
+				}
+				ws.Disconnect()
+				logger.Info("Disconnected from Slack")
+			}
+			if nextTime := sched.GetNextWorkingTimeAt(event.Time); !nextTime.IsZero() {
+				logger.Info("Outside working hours. Next working time: %s", formatTimeInLocation(nextTime, sched.Location()))
+			} else {
+				logger.Info("Outside working hours. No upcoming working time in the schedule")
+			}
+			armGuardTimer()
+		case <-changed:
+			armGuardTimer()
+		case <-guardWake:
+			guardTimer, guardWake = nil, nil
+			if resumed() {
+				continue
+			}
+			allowed, reason, resume := guard.Check(clk.Now())
+			switch {
+			case ws.IsConnected() && !allowed:
+				logger.Info("Active-time guard: %s, disconnecting from Slack. Connecting again at %s", reason, formatTimeInLocation(resume, sched.Location()))
+				ws.Disconnect()
+			case working && !ws.IsConnected() && allowed:
+				connect("Active-time break is over, connecting to Slack...")
+			}
+			armGuardTimer()
+		case <-healthTicker.C():
+			if resumed() {
+				continue
+			}
+			if live.Load() {
+				if err := guard.Update(clk.Now()); err != nil {
+					logger.Error("%v", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lucy/slack-always-active/clock"
+	"github.com/lucy/slack-always-active/schedule"
+	"github.com/lucy/slack-always-active/slackws"
+)
+
+// call is a Connect or Disconnect call made by the supervisor.
+type call struct {
+	kind string
+	at   time.Time
+}
+
+func (c call) String() string {
+	return fmt.Sprintf("%s at %s", c.kind, c.at.Format("Mon 15:04"))
+}
+
+// fakeSession records the calls made by the supervisor. It goes live as
+// soon as it is connected, unless delayLive is set.
+type fakeSession struct {
+	clk       clock.Clock
+	mu        sync.Mutex
+	connected bool
+	live      bool
+	delayLive bool
+	observers []func(slackws.StateChange)
+	calls     chan call
+}
+
+func newFakeSession(clk clock.Clock) *fakeSession {
+	return &fakeSession{clk: clk, calls: make(chan call, 100)}
+}
+
+func (s *fakeSession) Connect() error {
+	s.mu.Lock()
+	s.connected = true
+	delay := s.delayLive
+	s.mu.Unlock()
+	s.calls <- call{"connect", s.clk.Now()}
+	if !delay {
+		s.setLive(true)
+	}
+	return nil
+}
+
+func (s *fakeSession) Disconnect() {
+	s.mu.Lock()
+	s.connected = false
+	s.mu.Unlock()
+	s.setLive(false)
+	s.calls <- call{"disconnect", s.clk.Now()}
+}
+
+func (s *fakeSession) OnStateChange(fn func(slackws.StateChange)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observers = append(s.observers, fn)
+}
+
+// setLive makes the connection go live or drop, and tells the observers.
+func (s *fakeSession) setLive(live bool) {
+	s.mu.Lock()
+	if s.live == live {
+		s.mu.Unlock()
+		return
+	}
+	s.live = live
+	change := slackws.StateChange{From: slackws.Live, To: slackws.Closed, At: s.clk.Now()}
+	if live {
+		change = slackws.StateChange{From: slackws.AwaitingHello, To: slackws.Live, At: s.clk.Now()}
+	}
+	observers := s.observers
+	s.mu.Unlock()
+
+	for _, fn := range observers {
+		fn(change)
+	}
+}
+
+func (s *fakeSession) IsConnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connected
+}
+
+// harness runs the supervisor against a fake clock.
+type harness struct {
+	t       *testing.T
+	clk     *clock.Fake
+	sched   *schedule.Schedule
+	session *fakeSession
+	// waiters is the number of timers and tickers the supervisor and the
+	// schedule watcher hold while they are idle.
+	waiters int
+}
+
+func newHarness(t *testing.T, config *schedule.Config, start time.Time) *harness {
+	t.Helper()
+	sched, err := schedule.NewScheduleFromConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk := clock.NewFake(start)
+	sched.SetClock(clk)
+	guard, err := schedule.NewGuard(sched, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &harness{t: t, clk: clk, sched: sched, session: newFakeSession(clk), waiters: 3}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		supervise(ctx, sched, h.session, guard, clk)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return h
+}
+
+// run advances the clock minute by minute until end and returns the calls
+// made on the way. When the clock reaches a time in expect, it waits for the
+// supervisor to make that call before moving on.
+func (h *harness) run(end time.Time, expect []call) []call {
+	h.t.Helper()
+	pending := make(map[time.Time]int)
+	for _, c := range expect {
+		pending[c.at]++
+	}
+
+	var calls []call
+	for h.clk.Now().Before(end) {
+		h.clk.BlockUntil(h.waiters)
+		h.clk.Advance(time.Minute)
+		for ; pending[h.clk.Now()] > 0; pending[h.clk.Now()]-- {
+			select {
+			case c := <-h.session.calls:
+				calls = append(calls, c)
+			case <-time.After(5 * time.Second):
+				h.t.Fatalf("no call from the supervisor at %s", h.clk.Now().Format("Mon 15:04"))
+			}
+		}
+	}
+
+	// Pick up anything that was not expected
+	h.clk.BlockUntil(h.waiters)
+	for {
+		select {
+		case c := <-h.session.calls:
+			calls = append(calls, c)
+		case <-time.After(100 * time.Millisecond):
+			return calls
+		}
+	}
+}
+
+func TestSupervisorSimulatedWeek(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone not available: %v", err)
+	}
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 6, day, hour, minute, 0, 0, berlin)
+	}
+
+	// Start on Sunday 2024-06-02 at noon and run for a week
+	h := newHarness(t, &schedule.Config{
+		Timezone:  "Europe/Berlin",
+		WorkHours: "mon-thu 09:00-12:00,13:00-18:00; fri 09:00-14:00; sat 22:00-02:00",
+	}, at(2, 12, 0))
+
+	var expect []call
+	for day := 3; day <= 6; day++ {
+		expect = append(expect,
+			call{"connect", at(day, 9, 0)},
+			call{"disconnect", at(day, 12, 0)},
+			call{"connect", at(day, 13, 0)},
+			call{"disconnect", at(day, 18, 0)},
+		)
+	}
+	expect = append(expect,
+		call{"connect", at(7, 9, 0)},
+		call{"disconnect", at(7, 14, 0)},
+		call{"connect", at(8, 22, 0)},
+		call{"disconnect", at(9, 2, 0)},
+	)
+
+	calls := h.run(at(9, 12, 0), expect)
+	if len(calls) != len(expect) {
+		t.Errorf("got %d calls, want %d:\n%v", len(calls), len(expect), calls)
+	}
+	for i := 0; i < len(calls) && i < len(expect); i++ {
+		if calls[i].kind != expect[i].kind || !calls[i].at.Equal(expect[i].at) {
+			t.Errorf("call %d: got %s, want %s", i, calls[i], expect[i])
+		}
+	}
+}
+
+func TestSupervisorCountsLiveTime(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 6, 3, hour, minute, 0, 0, time.UTC)
+	}
+	h := newHarness(t, &schedule.Config{
+		WorkHours:    "mon 09:00-17:00",
+		MaxSession:   "2h",
+		SessionBreak: "15m",
+	}, at(8, 0))
+	h.session.mu.Lock()
+	h.session.delayLive = true
+	h.session.mu.Unlock()
+
+	calls := h.run(at(9, 30), []call{{"connect", at(9, 0)}})
+	if len(calls) != 1 {
+		t.Fatalf("got calls %v, want one connect", calls)
+	}
+
+	// The session only starts once the connection is live
+	h.clk.BlockUntil(h.waiters)
+	h.session.setLive(true)
+	h.waiters = 4
+	calls = h.run(at(10, 0), nil)
+
+	// A drop is for the session to recover from, not the supervisor
+	h.clk.BlockUntil(h.waiters)
+	h.session.setLive(false)
+	h.waiters = 3
+	calls = append(calls, h.run(at(10, 10), nil)...)
+	h.session.setLive(true)
+	h.waiters = 4
+
+	// The forced break starts two hours after the connection went live,
+	// and its timer connects again once it is over
+	calls = append(calls, h.run(at(11, 44), []call{{"disconnect", at(11, 30)}})...)
+	h.clk.BlockUntil(h.waiters)
+	h.clk.Advance(time.Minute)
+	select {
+	case c := <-h.session.calls:
+		calls = append(calls, c)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no connect after the break")
+	}
+
+	expect := []call{{"disconnect", at(11, 30)}, {"connect", at(11, 45)}}
+	if fmt.Sprint(calls) != fmt.Sprint(expect) {
+		t.Errorf("got calls %v, want %v", calls, expect)
+	}
+}
+
+func TestSupervisorFollowsOverride(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 6, 3, hour, minute, 0, 0, time.UTC)
+	}
+	h := newHarness(t, &schedule.Config{WorkHours: "mon 09:00-10:00"}, at(20, 0))
+
+	// Working late: the override connects right away and ends at 21:00
+	h.clk.BlockUntil(h.waiters)
+	if err := h.sched.ForceActiveUntil(at(21, 0)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case c := <-h.session.calls:
+		if c.kind != "connect" || !c.at.Equal(at(20, 0)) {
+			t.Errorf("got %s, want connect at 20:00", c)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the override did not connect")
+	}
+
+	expect := []call{{"disconnect", at(21, 0)}}
+	calls := h.run(at(22, 0), expect)
+	if fmt.Sprint(calls) != fmt.Sprint(expect) {
+		t.Errorf("got calls %v, want %v", calls, expect)
+	}
+}
+
+func TestSupervisorForcedBreaks(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 6, 3, hour, minute, 0, 0, time.UTC)
+	}
+	h := newHarness(t, &schedule.Config{
+		WorkHours:    "mon 09:00-17:00",
+		MaxSession:   "2h",
+		SessionBreak: "15m",
+	}, at(8, 0))
+
+	calls := h.run(at(9, 0), []call{{"connect", at(9, 0)}})
+	if len(calls) != 1 {
+		t.Fatalf("got calls %v, want one connect", calls)
+	}
+
+	// The guard timer is pending during working hours
+	h.waiters = 4
+	expect := []call{
+		{"disconnect", at(11, 0)},
+		{"connect", at(11, 15)},
+		{"disconnect", at(13, 15)},
+		{"connect", at(13, 30)},
+		{"disconnect", at(15, 30)},
+		{"connect", at(15, 45)},
+	}
+	calls = h.run(at(16, 59), expect)
+	h.waiters = 3
+	calls = append(calls, h.run(at(18, 0), []call{{"disconnect", at(17, 0)}})...)
+
+	expect = append(expect, call{"disconnect", at(17, 0)})
+	if fmt.Sprint(calls) != fmt.Sprint(expect) {
+		t.Errorf("got calls %v, want %v", calls, expect)
+	}
+}
+
+func TestSupervisorReconnectsAfterResume(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 6, 3, hour, minute, 0, 0, time.UTC)
+	}
+	h := newHarness(t, &schedule.Config{WorkHours: "mon 09:00-17:00"}, at(8, 0))
+
+	calls := h.run(at(9, 30), []call{{"connect", at(9, 0)}})
+	if len(calls) != 1 {
+		t.Fatalf("got calls %v, want one connect", calls)
+	}
+
+	// The laptop sleeps through lunch; the old connection is gone
+	h.clk.BlockUntil(h.waiters)
+	h.clk.Advance(2*time.Hour + 30*time.Minute)
+	expect := []call{{"disconnect", at(12, 0)}, {"connect", at(12, 0)}}
+	calls = nil
+	for range expect {
+		select {
+		case c := <-h.session.calls:
+			calls = append(calls, c)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no fresh connection after the resume, got %v", calls)
+		}
+	}
+	calls = append(calls, h.run(at(18, 0), []call{{"disconnect", at(17, 0)}})...)
+
+	expect = append(expect, call{"disconnect", at(17, 0)})
+	if fmt.Sprint(calls) != fmt.Sprint(expect) {
+		t.Errorf("got calls %v, want %v", calls, expect)
+	}
+}
+
+func TestSupervisorResumesOutsideWorkingHours(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 6, day, hour, minute, 0, 0, time.UTC)
+	}
+	h := newHarness(t, &schedule.Config{WorkHours: "mon-fri 09:00-17:00"}, at(3, 17, 30))
+
+	calls := h.run(at(3, 18, 0), nil)
+	if len(calls) != 0 {
+		t.Fatalf("got calls %v, want none", calls)
+	}
+	h.session.Connect()
+	<-h.session.calls
+
+	// Suspended in the evening and resumed the next morning before work
+	h.clk.BlockUntil(h.waiters)
+	h.clk.Set(at(4, 8, 0))
+	expect := []call{{"disconnect", at(4, 8, 0)}, {"connect", at(4, 9, 0)}}
+	calls = h.run(at(4, 9, 30), expect)
+	if fmt.Sprint(calls) != fmt.Sprint(expect) {
+		t.Errorf("got calls %v, want %v", calls, expect)
+	}
+}